@@ -1,4 +1,15 @@
-import { faAddressCard, faGlobe, faPlug, faQuestionCircle, faSatelliteDish } from '@fortawesome/free-solid-svg-icons'
+import {
+  faAddressCard,
+  faCalendarDays,
+  faClock,
+  faEnvelope,
+  faGlobe,
+  faPlug,
+  faQuestionCircle,
+  faRightLeft,
+  faSatelliteDish,
+  faServer,
+} from '@fortawesome/free-solid-svg-icons'
 import { FontAwesomeIcon as Fa } from '@fortawesome/react-fontawesome'
 import { Monitor } from '../types'
 
@@ -12,6 +23,16 @@ export default function MonitorIcon({ monitor }: { monitor: Monitor }) {
       return <Fa icon={faPlug} fixedWidth />
     case 'dns':
       return <Fa icon={faAddressCard} fixedWidth />
+    case 'domain':
+      return <Fa icon={faCalendarDays} fixedWidth />
+    case 'mail':
+      return <Fa icon={faEnvelope} fixedWidth />
+    case 'amqp':
+      return <Fa icon={faRightLeft} fixedWidth />
+    case 'ntp':
+      return <Fa icon={faClock} fixedWidth />
+    case 'system':
+      return <Fa icon={faServer} fixedWidth />
 
     default:
       return <Fa icon={faQuestionCircle} fixedWidth />
@@ -22,8 +22,11 @@ export default function ResultTable({ results, showName }: { results: ResultExte
             <td className={result.statusDetails.class}>
               <StatusPill statusCode={result.status} />
             </td>
-            <td className={result.statusDetails.class}>{result.value}</td>
-            <td className={result.statusDetails.class}>{result.message}</td>
+            <td className={result.statusDetails.class}>{result.valueNice}</td>
+            <td className={result.statusDetails.class}>
+              {result.error_class && <span className="badge bg-secondary me-2">{result.error_class}</span>}
+              {result.message}
+            </td>
             <td className={result.statusDetails.class}>
               <details className={result.outputs ? '' : 'd-none'}>
                 <summary>Click to see output</summary>
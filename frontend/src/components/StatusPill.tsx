@@ -1,4 +1,4 @@
-import { faQuestionCircle, faCheckCircle, faTriangleExclamation, faBolt, faBan } from '@fortawesome/free-solid-svg-icons'
+import { faQuestionCircle, faCheckCircle, faTriangleExclamation, faBolt, faBan, faCircleExclamation } from '@fortawesome/free-solid-svg-icons'
 import { FontAwesomeIcon as Fa } from '@fortawesome/react-fontawesome'
 
 type StatusPillProps = {
@@ -29,6 +29,12 @@ export default function StatusPill({ statusCode, large = false, className = '' }
           <Fa icon={faBolt} fixedWidth /> Failure
         </span>
       )
+    case 3:
+      return (
+        <span className={`badge bg-info text-dark ${fs} ${className}`} title="Warning">
+          <Fa icon={faCircleExclamation} fixedWidth /> Warning
+        </span>
+      )
     case -1:
       return (
         <span className={`badge bg-dark ${fs} ${className}`} title="Disabled">
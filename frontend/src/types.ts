@@ -13,6 +13,11 @@ export interface Monitor {
   interval: string
   target: string
   rule: string
+  warnRule?: string
+  critRule?: string
+  ruleEngine?: string
+  unit?: string
+  precision?: number
   enabled: boolean
   properties: { [key: string]: string }
   group: string
@@ -26,7 +31,8 @@ export interface MonitorFromDB extends Monitor {
 export const StatusOK = 0
 export const StatusError = 1
 export const StatusFailed = 2
-export type StatusCode = typeof StatusOK | typeof StatusError | typeof StatusFailed
+export const StatusWarning = 3
+export type StatusCode = typeof StatusOK | typeof StatusError | typeof StatusFailed | typeof StatusWarning
 
 // eslint-disable-next-line @typescript-eslint/no-explicit-any
 export type Output = { [key: string]: any }
@@ -36,12 +42,16 @@ export interface Result {
   status: StatusCode
   value: number
   message: string
+  error_class?: ErrorClass
   monitor_id: string
   monitor_name: string
   monitor_target: string
   outputs: Output
 }
 
+// Why a result isn't OK, missing when OK or the failure couldn't be classified
+export type ErrorClass = 'dns' | 'timeout' | 'connection_refused' | 'tls' | 'http_status' | 'rule' | 'config'
+
 export interface Status {
   code: number
   text: string
@@ -50,6 +60,7 @@ export interface Status {
 
 export interface ResultExtended extends Result {
   dateNice: string
+  valueNice: string
   statusDetails: Status
 }
 
@@ -70,6 +81,9 @@ export const NewEmptyMonitor: Monitor = {
   group: '',
 }
 
+// Properties which work with any type of monitor
+export const CommonProps = ['anomaly', 'anomalyOutput', 'anomalyWarn', 'anomalyCrit', 'anomalyAlpha', 'anomalyMinSamples', 'anomalyDirection']
+
 type MonitorDefinition = {
   ruleHint: string
   allowedProps: string[]
@@ -78,8 +92,50 @@ type MonitorDefinition = {
 
 export const MonitorDefinitions: Record<string, MonitorDefinition> = {
   http: {
-    ruleHint: 'respTime, status, body, bodyLen, certExpiryDays, regexMatch',
-    allowedProps: ['method', 'timeout', 'validateTLS', 'body', 'headers', 'bodyRegex'],
+    ruleHint: 'respTime, status, bodyLen, certExpiryDays, regexMatch, dnsTime, connectTime, tlsTime, ttfb, transferTime, redirectCount, finalUrl',
+    allowedProps: [
+      'method',
+      'timeout',
+      'validateTLS',
+      'body',
+      'headers',
+      'bodyRegex',
+      'expectStatus',
+      'expectKeyword',
+      'expectNoKeyword',
+      'maxBodySize',
+      'jsonPaths',
+      'detectChanges',
+      'changeIgnore',
+      'changeNormalise',
+      'changeSnapshots',
+      'caCert',
+      'clientCert',
+      'clientKey',
+      'proxy',
+      'keepAlive',
+      'httpVersion',
+      'source',
+      'followRedirects',
+      'maxRedirects',
+      'captureHeaders',
+      'auth',
+      'authUsername',
+      'authPassword',
+      'authToken',
+      'authKey',
+      'authKeyName',
+      'authKeyIn',
+      'authAccessKey',
+      'authSecretKey',
+      'authSessionToken',
+      'authRegion',
+      'authService',
+      'authTokenUrl',
+      'authClientId',
+      'authClientSecret',
+      'authScopes',
+    ],
     template: {
       name: 'New HTTP Monitor',
       type: 'http',
@@ -142,4 +198,86 @@ export const MonitorDefinitions: Record<string, MonitorDefinition> = {
       group: '',
     },
   },
+
+  domain: {
+    ruleHint: 'respTime, domain, expiryDays, expiryDate, registrar, status, nameservers, nameserverCount',
+    allowedProps: ['timeout', 'server'],
+    template: {
+      name: 'New Domain Monitor',
+      type: 'domain',
+      interval: '12h',
+      enabled: true,
+      target: 'example.net',
+      rule: 'expiryDays > 30',
+      properties: {},
+      group: '',
+    },
+  },
+
+  mail: {
+    ruleHint: 'respTime, banner, extensions, tlsAvailable, tlsActive, connectTime, bannerTime, helloTime, tlsTime, authTime, messageCount',
+    allowedProps: ['timeout', 'protocol', 'tls', 'validateTLS', 'helo', 'username', 'password', 'mailbox', 'caCert', 'clientCert', 'clientKey'],
+    template: {
+      name: 'New Mail Monitor',
+      type: 'mail',
+      interval: '60s',
+      enabled: true,
+      target: 'mail.example.net:25',
+      rule: 'tlsAvailable && respTime < 2000',
+      properties: {
+        protocol: 'smtp',
+      },
+      group: '',
+    },
+  },
+
+  amqp: {
+    ruleHint: 'respTime, connectTime, messageCount, consumerCount, roundTripTime, serverProduct, serverVersion',
+    allowedProps: ['timeout', 'username', 'password', 'vhost', 'queue', 'roundTrip', 'validateTLS', 'caCert', 'clientCert', 'clientKey'],
+    template: {
+      name: 'New AMQP Monitor',
+      type: 'amqp',
+      interval: '30s',
+      enabled: true,
+      target: 'localhost:5672',
+      rule: 'connectTime < 500',
+      properties: {
+        username: 'guest',
+        password: 'guest',
+      },
+      group: '',
+    },
+  },
+
+  ntp: {
+    ruleHint: 'respTime, offsetMs, delayMs, stratum, referenceId, leap, rootDelayMs, rootDispersionMs, serverTime',
+    allowedProps: ['timeout', 'version'],
+    template: {
+      name: 'New NTP Monitor',
+      type: 'ntp',
+      interval: '60s',
+      enabled: true,
+      target: 'pool.ntp.org',
+      rule: 'abs(offsetMs) < 100',
+      properties: {},
+      group: '',
+    },
+  },
+
+  system: {
+    ruleHint: 'cpuPercent, load1, load5, load15, memUsedPercent, swapUsedPercent, openFilesPercent, disk1UsedPercent, diskUsedPercentMax',
+    allowedProps: ['mounts', 'sampleTime', 'procPath'],
+    template: {
+      name: 'New System Monitor',
+      type: 'system',
+      interval: '60s',
+      enabled: true,
+      target: 'localhost',
+      rule: 'cpuPercent < 90 && memUsedPercent < 90 && diskUsedPercentMax < 90',
+      properties: {
+        mounts: '/',
+      },
+      group: '',
+    },
+  },
 }
@@ -26,6 +26,11 @@ export function getStatus(statusCode: number) {
       status.class = 'bg-danger text-light'
       break
 
+    case 3:
+      status.text = 'Warning'
+      status.class = 'bg-info text-dark'
+      break
+
     case -1:
       status.text = 'Disabled'
       status.class = 'bg-dark text-light'
@@ -39,6 +44,13 @@ export function getStatus(statusCode: number) {
   return status
 }
 
+/**
+ * Format a result value with the precision & unit of the monitor
+ */
+export function niceValue(value: number, precision = 0, unit = '') {
+  return `${value.toFixed(precision)}${unit ? ' ' + unit : ''}`
+}
+
 /**
  * Nice-ify a date string
  */
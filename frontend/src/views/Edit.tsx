@@ -3,7 +3,7 @@ import { FontAwesomeIcon as Fa } from '@fortawesome/react-fontawesome'
 import { faCheck, faEdit, faGears, faTrash, faXmark } from '@fortawesome/free-solid-svg-icons'
 import MonitorIcon from '../components/MonitorIcon'
 import { useEffect, useState } from 'react'
-import { MonitorFromDB, Monitor, MonitorDefinitions, NewEmptyMonitor } from '../types'
+import { MonitorFromDB, Monitor, MonitorDefinitions, NewEmptyMonitor, CommonProps } from '../types'
 import { useAPI } from '../providers'
 
 export default function Edit() {
@@ -15,7 +15,7 @@ export default function Edit() {
 
   const isNew = pathname === '/new' ? true : false
   const title = isNew ? 'Create New Monitor' : 'Update'
-  const types = ['http', 'tcp', 'ping', 'dns']
+  const types = ['http', 'tcp', 'ping', 'dns', 'domain', 'mail', 'amqp', 'ntp', 'system']
 
   const [rulePop, setRulePop] = useState(false)
   const [saving, setSaving] = useState(false)
@@ -216,6 +216,73 @@ export default function Edit() {
               </div>
             </div>
 
+            <div className="d-flex mt-2">
+              <div className="form-group me-3 flex-grow-1">
+                <label htmlFor="warnRule">Warning Rule</label>
+                <input
+                  id="warnRule"
+                  type="text"
+                  className="form-control"
+                  placeholder="Optional, when false the status is warning"
+                  value={monitor.warnRule || ''}
+                  onChange={(e) => setMonitor({ ...monitor, warnRule: e.target.value })}
+                />
+              </div>
+
+              <div className="form-group flex-grow-1">
+                <label htmlFor="critRule">Critical Rule</label>
+                <input
+                  id="critRule"
+                  type="text"
+                  className="form-control"
+                  placeholder="Optional, when false the status is error"
+                  value={monitor.critRule || ''}
+                  onChange={(e) => setMonitor({ ...monitor, critRule: e.target.value })}
+                />
+              </div>
+
+              <div className="form-group ms-3">
+                <label htmlFor="ruleEngine">Rule Engine</label>
+                <select
+                  className="form-control"
+                  id="ruleEngine"
+                  value={monitor.ruleEngine || ''}
+                  onChange={(e) => setMonitor({ ...monitor, ruleEngine: e.target.value })}
+                >
+                  <option value="">govaluate</option>
+                  <option value="cel">cel</option>
+                </select>
+              </div>
+            </div>
+
+            <div className="d-flex mt-2">
+              <div className="form-group me-3">
+                <label htmlFor="unit">Unit</label>
+                <input
+                  id="unit"
+                  type="text"
+                  className="form-control"
+                  placeholder="Optional, e.g. ms or %"
+                  value={monitor.unit || ''}
+                  onChange={(e) => setMonitor({ ...monitor, unit: e.target.value })}
+                  autoComplete="off"
+                />
+              </div>
+
+              <div className="form-group">
+                <label htmlFor="precision">Precision</label>
+                <input
+                  id="precision"
+                  type="number"
+                  min={0}
+                  max={6}
+                  className="form-control"
+                  value={monitor.precision || 0}
+                  onChange={(e) => setMonitor({ ...monitor, precision: parseInt(e.target.value) || 0 })}
+                />
+              </div>
+            </div>
+
             <div className="form-group mt-3">
               <div className="checkbox-container">
                 <label htmlFor="enabled">Enabled</label>
@@ -238,7 +305,7 @@ export default function Edit() {
                 Add Property
               </button>
               <ul className="dropdown-menu">
-                {MonitorDefinitions[monitor.type].allowedProps.map((prop, index) => (
+                {[...MonitorDefinitions[monitor.type].allowedProps, ...CommonProps].map((prop, index) => (
                   <li key={index}>
                     <a
                       className="dropdown-item case-link"
@@ -2,7 +2,7 @@ import { useCallback, useEffect, useState } from 'react'
 import { NavLink, useParams } from 'react-router'
 
 import { MonitorExtended, MonitorFromDB, ResultExtended } from '../types'
-import { getStatus, niceDate } from '../utils'
+import { getStatus, niceDate, niceValue } from '../utils'
 import MonitorIcon from '../components/MonitorIcon'
 import StatusPill from '../components/StatusPill'
 import ResultTable from '../components/ResultTable'
@@ -59,6 +59,7 @@ export default function Monitor({ isAuth }: { isAuth: boolean }) {
     const extendedResults = fetchedResults.map((result) => ({
       ...result,
       dateNice: new Date(result.date).toLocaleString(),
+      valueNice: niceValue(result.value, mon.precision, mon.unit),
       statusDetails: getStatus(result.status),
     })) as ResultExtended[]
 
@@ -73,7 +74,7 @@ export default function Monitor({ isAuth }: { isAuth: boolean }) {
 
     setChartData({
       labels: chartLabels,
-      datasets: [{ label: 'Value', data: chartValues, tension: 0.3, borderColor: 'rgb(46, 113, 214)', fill: true }],
+      datasets: [{ label: mon.unit ? `Value (${mon.unit})` : 'Value', data: chartValues, tension: 0.3, borderColor: 'rgb(46, 113, 214)', fill: true }],
     })
 
     setResults(extendedResults)
@@ -186,6 +187,22 @@ export default function Monitor({ isAuth }: { isAuth: boolean }) {
                 <td>Rule(s):</td>
                 <td>{monitor.rule}</td>
               </tr>
+              <tr className={monitor.warnRule ? '' : 'd-none'}>
+                <td>Warning Rule:</td>
+                <td>{monitor.warnRule}</td>
+              </tr>
+              <tr className={monitor.critRule ? '' : 'd-none'}>
+                <td>Critical Rule:</td>
+                <td>{monitor.critRule}</td>
+              </tr>
+              <tr className={monitor.ruleEngine ? '' : 'd-none'}>
+                <td>Rule Engine:</td>
+                <td>{monitor.ruleEngine}</td>
+              </tr>
+              <tr className={monitor.unit ? '' : 'd-none'}>
+                <td>Unit:</td>
+                <td>{monitor.unit}</td>
+              </tr>
               <tr>
                 <td>Updated:</td>
                 <td>{updatedDate}</td>
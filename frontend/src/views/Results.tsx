@@ -1,8 +1,8 @@
 import { useEffect, useState } from 'react'
 import ResultTable from '../components/ResultTable'
 import { useAPI, useConfig } from '../providers'
-import { Result, ResultExtended } from '../types'
-import { getStatus, niceDate } from '../utils'
+import { MonitorFromDB, Result, ResultExtended } from '../types'
+import { getStatus, niceDate, niceValue } from '../utils'
 import Footer from '../components/Footer'
 
 let timeoutId: number
@@ -22,8 +22,10 @@ export default function Results() {
     async function fetchMonitors(repeat = true) {
       setLoading(true)
       let fetchedResults: Result[] = []
+      let monitors: MonitorFromDB[] = []
       try {
         fetchedResults = await api.getResults()
+        monitors = await api.getMonitors()
       } catch (err) {
         if (err instanceof Error) {
           setError(err.message)
@@ -31,11 +33,19 @@ export default function Results() {
         console.error(err)
       }
 
-      const results = fetchedResults.map((result) => ({
-        ...result,
-        statusDetails: getStatus(result.status),
-        dateNice: niceDate(result.date),
-      })) as ResultExtended[]
+      // Values are shown with the unit & precision of the monitor they came from
+      const monitorsById = new Map(monitors.map((m) => [m.id.toString(), m]))
+
+      const results = fetchedResults.map((result) => {
+        const mon = monitorsById.get(result.monitor_id.toString())
+
+        return {
+          ...result,
+          statusDetails: getStatus(result.status),
+          dateNice: niceDate(result.date),
+          valueNice: niceValue(result.value, mon?.precision, mon?.unit),
+        }
+      }) as ResultExtended[]
 
       setUpdated(new Date())
       setResults(results)
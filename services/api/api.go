@@ -20,6 +20,9 @@ type API struct {
 
 	// Instance of our DB connection
 	db *database.DB
+
+	// Can monitors be run from the API, see testMonitor
+	allowTest bool
 }
 
 // These are all GET and can be called without auth
@@ -28,16 +31,20 @@ func (api API) addAnonymousRoutes(r chi.Router) {
 	r.Get("/api/monitors/{id}", api.getMonitor)
 	r.Get("/api/monitors/{id}/results", api.getMonitorResults)
 	r.Get("/api/results", api.getResults)
+	r.Get("/api/outputs/{type}", api.getOutputs)
 }
 
 // These routes might be behind auth if it has been enabled
 func (api API) addProtectedRoutes(r chi.Router) {
 	r.Post("/api/monitors", api.createMonitor)
 	r.Post("/api/monitors/import", api.importMonitors)
+	r.Post("/api/monitors/test", api.testMonitor)
+	r.Post("/api/monitors/{id}/test", api.testMonitorRules)
 	r.Delete("/api/monitors", api.deleteMonitors)
 	r.Delete("/api/results", api.deleteResults)
 	r.Delete("/api/monitors/{id}", api.deleteMonitor)
 	r.Put("/api/monitors/{id}", api.updateMonitor)
+	r.Get("/api/monitors/{id}/snapshots", api.getMonitorSnapshots)
 }
 
 // Create an API with the given database context
@@ -45,5 +52,6 @@ func NewAPI(db *database.DB) API {
 	return API{
 		api.NewBase(serviceName, version, buildInfo, true),
 		db,
+		false,
 	}
 }
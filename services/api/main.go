@@ -50,6 +50,9 @@ func main() {
 	// Core API wrapping base go-rest-api/pkg/api
 	api := NewAPI(db)
 
+	// Running a monitor from the API can reach any host, so it needs auth or to be switched on
+	api.allowTest = os.Getenv("AUTH_CLIENT_ID") != "" || env.GetEnvBool("ALLOW_MONITOR_TEST", false)
+
 	// Some basic middleware, change as you see fit, see: https://github.com/go-chi/chi#core-middlewares
 	router.Use(middleware.RealIP)
 	// Filtered request logger, exclude /metrics & /health endpoints
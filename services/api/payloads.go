@@ -6,12 +6,15 @@
 package main
 
 import (
+	"fmt"
+	"math"
+	"net/http"
 	"slices"
+	"strings"
 	"time"
 
 	"nanomon/services/common/monitor"
-
-	"github.com/Knetic/govaluate"
+	"nanomon/services/common/result"
 )
 
 // Output struct for a monitor result
@@ -22,6 +25,11 @@ type MonitorResp struct {
 	Interval   string            `json:"interval"`
 	Target     string            `json:"target"`
 	Rule       string            `json:"rule,omitempty"`
+	WarnRule   string            `json:"warnRule,omitempty"`
+	CritRule   string            `json:"critRule,omitempty"`
+	RuleEngine string            `json:"ruleEngine,omitempty"`
+	Unit       string            `json:"unit,omitempty"`
+	Precision  int               `json:"precision,omitempty"`
 	Updated    time.Time         `json:"updated"`
 	Enabled    bool              `json:"enabled"`
 	Properties map[string]string `json:"properties"`
@@ -35,12 +43,28 @@ type MonitorReq struct {
 	Interval   string
 	Target     string
 	Rule       string
+	WarnRule   string
+	CritRule   string
+	RuleEngine string
+	Unit       string
+	Precision  int
 	Updated    time.Time
 	Enabled    bool
 	Properties map[string]string
 	Group      string
 }
 
+// Format for logging, with any credentials in the properties hidden
+func (m MonitorReq) String() string {
+	// Alias type has no String method, to avoid recursion
+	type plainReq MonitorReq
+
+	m.Target = monitor.RedactTarget(m.Target)
+	m.Properties = monitor.RedactProps(m.Properties)
+
+	return fmt.Sprintf("%+v", plainReq(m))
+}
+
 // Validate incoming monitor request
 func (m MonitorReq) validate() (string, bool) {
 	if m.Name == "" {
@@ -73,26 +97,130 @@ func (m MonitorReq) validate() (string, bool) {
 		return "monitor interval must be greater than 1s", false
 	}
 
-	if m.Rule != "" {
-		_, err = govaluate.NewEvaluableExpression(m.Rule)
+	if m.RuleEngine != "" && !slices.Contains(monitor.RuleEngines, m.RuleEngine) {
+		return "invalid rule engine", false
+	}
+
+	if len(m.Unit) > 20 {
+		return "unit must be 20 characters or less", false
+	}
+
+	if m.Precision < 0 || m.Precision > 6 {
+		return "precision must be between 0 and 6", false
+	}
+
+	if m.Type == monitor.TypeHTTP && m.Properties["jsonPaths"] != "" {
+		if err := monitor.ValidateJSONPaths(m.Properties["jsonPaths"]); err != nil {
+			return err.Error(), false
+		}
+	}
+
+	return validateRules(m.Type, m.RuleEngine, m.Rule, m.WarnRule, m.CritRule)
+}
+
+// Credentials which are still redacted, e.g. from an export, can't be saved as the monitor
+// would use the redacted value. Updates restore them from the stored monitor before this
+func (m MonitorReq) checkRedacted() (string, bool) {
+	if name, found := monitor.FindRedacted(m.Target, m.Properties); found {
+		return name + " holds a redacted credential, it must be set again", false
+	}
+
+	return "", true
+}
+
+// Check the rules can all be parsed, empty rules are allowed
+func validateRules(monitorType, engine, rule, warnRule, critRule string) (string, bool) {
+	rules := map[string]string{"rule": rule, "warnRule": warnRule, "critRule": critRule}
+	for name, rule := range rules {
+		if rule == "" {
+			continue
+		}
+
+		err := monitor.ValidateRule(monitorType, engine, rule)
 		if err != nil {
-			return "rule invalid: " + err.Error(), false
+			return name + " invalid: " + err.Error(), false
 		}
 	}
 
 	return "", true
 }
 
+// Longest timeout allowed when a monitor is run from the API
+const maxTestTimeout = 30 * time.Second
+
+// Extra checks for a monitor run from the API, which is reachable by more people than the runner
+func (m MonitorReq) validateTest() (string, bool) {
+	if m.Type == monitor.TypeSystem {
+		return "system monitors check the host they run on, they can't be tested from the API", false
+	}
+
+	// A timeout which can't be parsed is left for the monitor to report, as it would be by the runner
+	if timeout, err := time.ParseDuration(m.Properties["timeout"]); err == nil && timeout > maxTestTimeout {
+		return fmt.Sprintf("timeout can't be more than %s when testing a monitor", maxTestTimeout), false
+	}
+
+	return "", true
+}
+
+// Request struct for testing rules against the stored results of a monitor
+type RuleTestReq struct {
+	Rule     string
+	WarnRule string
+	CritRule string
+	Max      int
+}
+
+// Rules are checked with the type & rule engine of the monitor they'll be tested against
+func (r RuleTestReq) validate(mon *monitor.Monitor) (string, bool) {
+	if r.Max > 1000 || r.Max < 1 {
+		return "max must be between 1 and 1000", false
+	}
+
+	return validateRules(mon.Type, mon.RuleEngine, r.Rule, r.WarnRule, r.CritRule)
+}
+
+// Output struct for a test run of a monitor, includes the outputs only available to rules
+type TestResultResp struct {
+	*result.Result
+	RuleOutputs map[string]any `json:"ruleOutputs,omitempty"`
+}
+
 func MonitorToResp(m *monitor.Monitor) MonitorResp {
 	return MonitorResp{
 		ID:         m.ID,
 		Name:       m.Name,
 		Type:       m.Type,
 		Interval:   m.Interval,
-		Target:     m.Target,
+		Target:     monitor.RedactTarget(m.Target),
 		Rule:       m.Rule,
+		WarnRule:   m.WarnRule,
+		CritRule:   m.CritRule,
+		RuleEngine: m.RuleEngine,
+		Unit:       m.Unit,
+		Precision:  m.Precision,
 		Updated:    m.Updated,
 		Enabled:    m.Enabled,
-		Properties: m.Properties,
+		Properties: monitor.RedactProps(m.Properties),
+	}
+}
+
+// Values used to be whole numbers, clients which can't handle fractions can ask for them rounded
+func roundValues(req *http.Request, results []*result.Result) {
+	if req.URL.Query().Get("roundValues") != "true" {
+		return
 	}
+
+	for _, r := range results {
+		r.Value = math.Round(r.Value)
+	}
+}
+
+// Results can be filtered by the class of error, e.g. ?errorClass=dns
+func errorClassFilter(req *http.Request) (string, error) {
+	errorClass := req.URL.Query().Get("errorClass")
+	if errorClass != "" && !slices.Contains(result.ErrorClasses, errorClass) {
+		return "", fmt.Errorf("errorClass must be one of: %s", strings.Join(result.ErrorClasses, ", "))
+	}
+
+	return errorClass, nil
 }
@@ -13,6 +13,7 @@ import (
 	"nanomon/services/common/monitor"
 	"nanomon/services/common/result"
 	"net/http"
+	"slices"
 	"strconv"
 	"time"
 
@@ -94,7 +95,13 @@ func (api API) getMonitorResults(resp http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	results, err := result.GetResultsForMonitor(api.db, idInt, max)
+	errorClass, err := errorClassFilter(req)
+	if err != nil {
+		problem.Wrap(400, req.RequestURI, "results", err).Send(resp)
+		return
+	}
+
+	results, err := result.GetResultsForMonitor(api.db, idInt, max, errorClass)
 	if err != nil {
 		problem.Wrap(500, req.RequestURI, "results", err).Send(resp)
 		return
@@ -104,9 +111,30 @@ func (api API) getMonitorResults(resp http.ResponseWriter, req *http.Request) {
 		results = []*result.Result{}
 	}
 
+	roundValues(req, results)
+
 	api.ReturnJSON(resp, results)
 }
 
+// Get the body snapshots for monitor with id, kept when content change detection is on
+func (api API) getMonitorSnapshots(resp http.ResponseWriter, req *http.Request) {
+	id := chi.URLParam(req, "id")
+	// Convert to int
+	idInt, err := strconv.Atoi(id)
+	if err != nil {
+		problem.Wrap(400, req.RequestURI, "monitors", err).Send(resp)
+		return
+	}
+
+	snapshots, err := monitor.GetSnapshots(api.db, idInt)
+	if err != nil {
+		problem.Wrap(500, req.RequestURI, "snapshots", err).Send(resp)
+		return
+	}
+
+	api.ReturnJSON(resp, snapshots)
+}
+
 // Delete a monitor
 func (api API) deleteMonitor(resp http.ResponseWriter, req *http.Request) {
 	id := chi.URLParam(req, "id")
@@ -147,6 +175,11 @@ func (api API) createMonitor(resp http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if msg, ok := m.checkRedacted(); !ok {
+		problem.Wrap(400, req.RequestURI, "monitors", errors.New(msg)).Send(resp)
+		return
+	}
+
 	log.Printf("Creating monitor %+v", m)
 	m.Updated = time.Now()
 
@@ -155,6 +188,11 @@ func (api API) createMonitor(resp http.ResponseWriter, req *http.Request) {
 		Type:       m.Type,
 		Target:     m.Target,
 		Rule:       m.Rule,
+		WarnRule:   m.WarnRule,
+		CritRule:   m.CritRule,
+		RuleEngine: m.RuleEngine,
+		Unit:       m.Unit,
+		Precision:  m.Precision,
 		Interval:   m.Interval,
 		Updated:    m.Updated,
 		Enabled:    m.Enabled,
@@ -167,17 +205,7 @@ func (api API) createMonitor(resp http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	respMonitor := MonitorResp{
-		ID:         monitor.ID,
-		Name:       m.Name,
-		Type:       m.Type,
-		Interval:   m.Interval,
-		Updated:    m.Updated,
-		Enabled:    m.Enabled,
-		Properties: m.Properties,
-	}
-
-	api.ReturnJSON(resp, respMonitor)
+	api.ReturnJSON(resp, MonitorToResp(monitor))
 }
 
 // Update existing monitor with a PUT request and upsert into the DB
@@ -205,7 +233,27 @@ func (api API) updateMonitor(resp http.ResponseWriter, req *http.Request) {
 
 	m.Updated = time.Now()
 
-	log.Printf("Monitor properties: %+v", m.Target)
+	log.Printf("Updating monitor %+v", m)
+
+	// Credentials are redacted when monitors are fetched, so keep the stored ones
+	stored, err := monitor.FetchMonitor(api.db, idInt)
+	if errors.Is(err, sql.ErrNoRows) {
+		problem.Wrap(404, req.RequestURI, "monitors", errors.New("monitor not found")).Send(resp)
+		return
+	}
+
+	if err != nil {
+		problem.Wrap(500, req.RequestURI, "monitors", err).Send(resp)
+		return
+	}
+
+	m.Target = monitor.RestoreTarget(m.Target, stored.Target)
+	monitor.RestoreProps(m.Properties, stored.Properties)
+
+	if msg, ok := m.checkRedacted(); !ok {
+		problem.Wrap(400, req.RequestURI, "monitors", errors.New(msg)).Send(resp)
+		return
+	}
 
 	monitor := &monitor.Monitor{
 		ID:         idInt,
@@ -213,6 +261,11 @@ func (api API) updateMonitor(resp http.ResponseWriter, req *http.Request) {
 		Type:       m.Type,
 		Target:     m.Target,
 		Rule:       m.Rule,
+		WarnRule:   m.WarnRule,
+		CritRule:   m.CritRule,
+		RuleEngine: m.RuleEngine,
+		Unit:       m.Unit,
+		Precision:  m.Precision,
 		Interval:   m.Interval,
 		Updated:    m.Updated,
 		Enabled:    m.Enabled,
@@ -230,18 +283,109 @@ func (api API) updateMonitor(resp http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	respMonitor := MonitorResp{
-		ID:         monitor.ID,
+	api.ReturnJSON(resp, MonitorToResp(monitor))
+}
+
+// Run a monitor once without saving it, to check the settings & rules
+func (api API) testMonitor(resp http.ResponseWriter, req *http.Request) {
+	if !api.allowTest {
+		problem.Wrap(403, req.RequestURI, "monitors", errors.New("testing monitors is disabled, enable auth or set ALLOW_MONITOR_TEST")).Send(resp)
+		return
+	}
+
+	m := MonitorReq{}
+
+	err := json.NewDecoder(req.Body).Decode(&m)
+	if err != nil {
+		problem.Wrap(400, req.RequestURI, "monitors", err).Send(resp)
+		return
+	}
+
+	if msg, ok := m.validate(); !ok {
+		problem.Wrap(400, req.RequestURI, "monitors", errors.New(msg)).Send(resp)
+		return
+	}
+
+	if msg, ok := m.validateTest(); !ok {
+		problem.Wrap(400, req.RequestURI, "monitors", errors.New(msg)).Send(resp)
+		return
+	}
+
+	log.Printf("Testing monitor %+v", m)
+
+	monitor := &monitor.Monitor{
 		Name:       m.Name,
 		Type:       m.Type,
 		Target:     m.Target,
+		Rule:       m.Rule,
+		WarnRule:   m.WarnRule,
+		CritRule:   m.CritRule,
+		RuleEngine: m.RuleEngine,
+		Unit:       m.Unit,
+		Precision:  m.Precision,
 		Interval:   m.Interval,
-		Updated:    m.Updated,
 		Enabled:    m.Enabled,
 		Properties: m.Properties,
 	}
 
-	api.ReturnJSON(resp, respMonitor)
+	res := monitor.DryRun()
+
+	api.ReturnJSON(resp, TestResultResp{
+		Result:      res,
+		RuleOutputs: res.RuleOutputs,
+	})
+}
+
+// Evaluate candidate rules against the stored results of a monitor, without running it
+func (api API) testMonitorRules(resp http.ResponseWriter, req *http.Request) {
+	id := chi.URLParam(req, "id")
+	// Convert to int
+	idInt, err := strconv.Atoi(id)
+	if err != nil {
+		problem.Wrap(400, req.RequestURI, "monitors", err).Send(resp)
+		return
+	}
+
+	r := RuleTestReq{}
+
+	err = json.NewDecoder(req.Body).Decode(&r)
+	if err != nil {
+		problem.Wrap(400, req.RequestURI, "monitors", err).Send(resp)
+		return
+	}
+
+	mon, err := monitor.FetchMonitor(api.db, idInt)
+	if errors.Is(err, sql.ErrNoRows) {
+		problem.Wrap(404, req.RequestURI, "monitors", errors.New("monitor not found")).Send(resp)
+		return
+	}
+
+	if err != nil {
+		problem.Wrap(500, req.RequestURI, "monitors", err).Send(resp)
+		return
+	}
+
+	if r.Max == 0 {
+		r.Max = 20
+	}
+
+	if msg, ok := r.validate(mon); !ok {
+		problem.Wrap(400, req.RequestURI, "monitors", errors.New(msg)).Send(resp)
+		return
+	}
+
+	results, err := result.GetResultsForMonitor(api.db, idInt, r.Max, "")
+	if err != nil {
+		problem.Wrap(500, req.RequestURI, "results", err).Send(resp)
+		return
+	}
+
+	// The candidate rules replace all of the monitor's rules
+	mon.Rule = r.Rule
+	mon.WarnRule = r.WarnRule
+	mon.CritRule = r.CritRule
+
+	api.ReturnJSON(resp, mon.Replay(results))
 }
 
 // Get results across all monitors
@@ -263,7 +407,13 @@ func (api API) getResults(resp http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	results, err := result.GetResults(api.db, max)
+	errorClass, err := errorClassFilter(req)
+	if err != nil {
+		problem.Wrap(400, req.RequestURI, "results", err).Send(resp)
+		return
+	}
+
+	results, err := result.GetResults(api.db, max, errorClass)
 	if err != nil {
 		problem.Wrap(500, req.RequestURI, "results", err).Send(resp)
 		return
@@ -273,9 +423,22 @@ func (api API) getResults(resp http.ResponseWriter, req *http.Request) {
 		results = []*result.Result{}
 	}
 
+	roundValues(req, results)
+
 	api.ReturnJSON(resp, results)
 }
 
+// Get the outputs a type of monitor returns, with their types, units & descriptions
+func (api API) getOutputs(resp http.ResponseWriter, req *http.Request) {
+	monitorType := chi.URLParam(req, "type")
+	if !slices.Contains(monitor.ValidTypes, monitorType) {
+		problem.Wrap(404, req.RequestURI, "outputs", errors.New("monitor type not found")).Send(resp)
+		return
+	}
+
+	api.ReturnJSON(resp, monitor.TypeOutputDefs(monitorType))
+}
+
 // Import JSON to bulk configure monitors
 func (api API) importMonitors(resp http.ResponseWriter, req *http.Request) {
 	log.Printf("Importing monitors from request body")
@@ -294,11 +457,21 @@ func (api API) importMonitors(resp http.ResponseWriter, req *http.Request) {
 			return
 		}
 
+		if msg, ok := m.checkRedacted(); !ok {
+			problem.Wrap(400, req.RequestURI, "monitors", errors.New(msg)).Send(resp)
+			return
+		}
+
 		monitor := &monitor.Monitor{
 			Name:       m.Name,
 			Type:       m.Type,
 			Target:     m.Target,
 			Rule:       m.Rule,
+			WarnRule:   m.WarnRule,
+			CritRule:   m.CritRule,
+			RuleEngine: m.RuleEngine,
+			Unit:       m.Unit,
+			Precision:  m.Precision,
 			Interval:   m.Interval,
 			Updated:    time.Now(),
 			Enabled:    m.Enabled,
@@ -0,0 +1,210 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon Runner - AMQP monitor implementation, e.g. for RabbitMQ
+// ----------------------------------------------------------------------------
+
+package monitor
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"nanomon/services/common/result"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// The parts of an AMQP channel used by the checks, so they can be tested without a broker
+type amqpChannel interface {
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+func (m *Monitor) runAMQP() *result.Result {
+	r := result.NewResult(m.Name, m.Target, m.ID)
+
+	var err error
+
+	timeout := time.Duration(5) * time.Second
+	validateTLS := true
+	roundTrip := false
+
+	timeoutProp := m.Properties["timeout"]
+	if timeoutProp != "" {
+		timeout, err = time.ParseDuration(timeoutProp)
+		if err != nil {
+			return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
+		}
+	}
+
+	validateTLSProp := m.Properties["validateTLS"]
+	if validateTLSProp != "" {
+		validateTLS, err = strconv.ParseBool(validateTLSProp)
+		if err != nil {
+			return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
+		}
+	}
+
+	roundTripProp := m.Properties["roundTrip"]
+	if roundTripProp != "" {
+		roundTrip, err = strconv.ParseBool(roundTripProp)
+		if err != nil {
+			return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
+		}
+	}
+
+	// Target can be a full AMQP URI, or simply host:port
+	target := m.Target
+	if !strings.HasPrefix(target, "amqp://") && !strings.HasPrefix(target, "amqps://") {
+		target = "amqp://" + target
+	}
+
+	uri, err := amqp.ParseURI(target)
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
+	}
+
+	// Properties take precedence over anything in the URI
+	if m.Properties["username"] != "" {
+		uri.Username = m.Properties["username"]
+	}
+
+	if m.Properties["password"] != "" {
+		uri.Password = m.Properties["password"]
+	}
+
+	if m.Properties["vhost"] != "" {
+		uri.Vhost = m.Properties["vhost"]
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         uri.Host,
+		InsecureSkipVerify: !validateTLS,
+	}
+
+	err = applyTLSProps(tlsConfig, m.Properties)
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
+	}
+
+	config := amqp.Config{
+		SASL:            []amqp.Authentication{&amqp.PlainAuth{Username: uri.Username, Password: uri.Password}},
+		Vhost:           uri.Vhost,
+		Dial:            amqp.DefaultDial(timeout),
+		TLSClientConfig: tlsConfig,
+		Properties:      amqp.Table{"connection_name": "nanomon: " + m.Name},
+	}
+
+	// Credentials are passed in the config, so they are left out of the URL
+	url := fmt.Sprintf("%s://%s/", uri.Scheme, net.JoinHostPort(uri.Host, strconv.Itoa(uri.Port)))
+	start := time.Now()
+
+	conn, err := amqp.DialConfig(url, config)
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+	}
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+	}
+	defer ch.Close()
+
+	connectTime := int(time.Since(start).Milliseconds())
+
+	outputs := map[string]any{
+		"connectTime":   connectTime,
+		"serverProduct": conn.Properties["product"],
+		"serverVersion": conn.Properties["version"],
+	}
+
+	err = amqpChecks(ch, m.Properties["queue"], roundTrip, timeout, outputs)
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+	}
+
+	r.Value = durationMs(time.Since(start))
+	outputs["respTime"] = int(r.Value)
+
+	r.Outputs = outputs
+
+	return r
+}
+
+// Check the queue when one is set, and do the round trip when enabled, adding their outputs
+func amqpChecks(ch amqpChannel, queue string, roundTrip bool, timeout time.Duration, outputs map[string]any) error {
+	// Passive declare only checks the queue, it will error if it does not exist
+	if queue != "" {
+		q, err := ch.QueueDeclarePassive(queue, false, false, false, false, nil)
+		if err != nil {
+			return err
+		}
+
+		outputs["messageCount"] = q.Messages
+		outputs["consumerCount"] = q.Consumers
+	}
+
+	if roundTrip {
+		rtt, err := amqpRoundTrip(ch, timeout)
+		if err != nil {
+			return err
+		}
+
+		outputs["roundTripTime"] = rtt
+	}
+
+	return nil
+}
+
+// Publish a message and consume it back, using a temporary queue so real
+// messages are never touched. Returns the round trip time in milliseconds
+func amqpRoundTrip(ch amqpChannel, timeout time.Duration) (int, error) {
+	// Server named, exclusive & auto-deleted, so it goes away with the connection
+	q, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	deliveries, err := ch.Consume(q.Name, "", true, true, false, false, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	msgID := fmt.Sprintf("nanomon-%d", time.Now().UnixNano())
+	start := time.Now()
+
+	err = ch.PublishWithContext(ctx, "", q.Name, false, false, amqp.Publishing{
+		MessageId:   msgID,
+		ContentType: "text/plain",
+		Body:        []byte("nanomon round trip check"),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		select {
+		case msg, ok := <-deliveries:
+			if !ok {
+				return 0, fmt.Errorf("channel closed before message was received")
+			}
+
+			if msg.MessageId == msgID {
+				return int(time.Since(start).Milliseconds()), nil
+			}
+
+		case <-ctx.Done():
+			return 0, fmt.Errorf("timed out waiting for round trip message")
+		}
+	}
+}
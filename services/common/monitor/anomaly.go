@@ -0,0 +1,295 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon Runner - Anomaly detection, flags values far from a rolling baseline
+// ----------------------------------------------------------------------------
+
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"nanomon/services/common/database"
+	"nanomon/services/common/result"
+	"strconv"
+	"time"
+)
+
+// Modes of anomaly detection, a single baseline or one for each hour of the week
+const anomalyEWMA = "ewma"
+const anomalySeasonal = "seasonal"
+
+// Running mean & variance of a value
+type baseline struct {
+	count    int
+	mean     float64
+	variance float64
+}
+
+// Baselines for a monitor, only one of these is used depending on the mode
+type anomalyState struct {
+	rolling baseline
+	buckets [7 * 24]baseline
+}
+
+// Settings for anomaly detection, from the monitor properties
+type anomalySettings struct {
+	mode       string
+	output     string
+	warn       float64
+	crit       float64
+	alpha      float64
+	minSamples int
+	direction  string
+}
+
+// Exponentially weighted update, so recent values count for more
+func (b *baseline) addEWMA(x, alpha float64) {
+	if b.count == 0 {
+		b.mean = x
+	} else {
+		diff := x - b.mean
+		incr := alpha * diff
+		b.mean += incr
+		b.variance = (1 - alpha) * (b.variance + diff*incr)
+	}
+
+	b.count++
+}
+
+// Welford's algorithm, every value counts equally
+func (b *baseline) add(x float64) {
+	b.count++
+	diff := x - b.mean
+	b.mean += diff / float64(b.count)
+	b.variance += (diff*(x-b.mean) - b.variance) / float64(b.count)
+}
+
+// A small floor on the spread, so perfectly steady values don't divide by zero
+func (b *baseline) stdDev() float64 {
+	return max(math.Sqrt(b.variance), math.Abs(b.mean)*0.01, 1e-6)
+}
+
+// Hours since the start of the week in UTC, Sunday is 0 the same as Postgres
+func hourOfWeek(t time.Time) int {
+	t = t.UTC()
+
+	return int(t.Weekday())*24 + t.Hour()
+}
+
+func (m *Monitor) anomalySettings() (*anomalySettings, error) {
+	var err error
+
+	s := &anomalySettings{
+		mode:       m.Properties["anomaly"],
+		output:     "value",
+		warn:       3,
+		alpha:      0.1,
+		minSamples: 20,
+		direction:  "both",
+	}
+
+	if s.mode != anomalyEWMA && s.mode != anomalySeasonal {
+		return nil, fmt.Errorf("anomaly: must be '%s' or '%s'", anomalyEWMA, anomalySeasonal)
+	}
+
+	if m.Properties["anomalyOutput"] != "" {
+		s.output = m.Properties["anomalyOutput"]
+	}
+
+	for prop, target := range map[string]*float64{"anomalyWarn": &s.warn, "anomalyCrit": &s.crit, "anomalyAlpha": &s.alpha} {
+		if m.Properties[prop] != "" {
+			*target, err = strconv.ParseFloat(m.Properties[prop], 64)
+			if err != nil || *target < 0 {
+				return nil, fmt.Errorf("%s: must be a positive number", prop)
+			}
+		}
+	}
+
+	if s.alpha <= 0 || s.alpha > 1 {
+		return nil, fmt.Errorf("anomalyAlpha: must be between 0 and 1")
+	}
+
+	if m.Properties["anomalyMinSamples"] != "" {
+		s.minSamples, err = strconv.Atoi(m.Properties["anomalyMinSamples"])
+		if err != nil || s.minSamples < 2 {
+			return nil, fmt.Errorf("anomalyMinSamples: must be a number of at least 2")
+		}
+	}
+
+	switch m.Properties["anomalyDirection"] {
+	case "":
+	case "both", "high", "low":
+		s.direction = m.Properties["anomalyDirection"]
+	default:
+		return nil, fmt.Errorf("anomalyDirection: must be 'both', 'high' or 'low'")
+	}
+
+	return s, nil
+}
+
+// Compare the value with the baseline, setting the baseline, baselineStdDev & zScore outputs.
+// Values too many standard deviations away give warning or error status. The value is then
+// added to the baseline, this happens even for anomalies so the baseline follows real shifts
+func (m *Monitor) detectAnomaly(res *result.Result) error {
+	if m.Properties["anomaly"] == "" || res.Outputs == nil {
+		return nil
+	}
+
+	s, err := m.anomalySettings()
+	if err != nil {
+		return err
+	}
+
+	// Outputs can be missing on some runs, e.g. a JSON path not in the body, so the check is
+	// skipped rather than failing the result, the baseline is left as it is
+	x, ok := newSample(res).values[s.output]
+	if !ok {
+		log.Printf("Monitor '%s' has no number output '%s', skipping anomaly check", m.Name, s.output)
+		return nil
+	}
+
+	if m.anomaly == nil {
+		m.anomaly = &anomalyState{}
+	}
+
+	b := &m.anomaly.rolling
+	if s.mode == anomalySeasonal {
+		b = &m.anomaly.buckets[hourOfWeek(res.Date)]
+	}
+
+	if b.count >= s.minSamples {
+		zScore := (x - b.mean) / b.stdDev()
+
+		res.Outputs["baseline"] = b.mean
+		res.Outputs["baselineStdDev"] = math.Sqrt(b.variance)
+		res.Outputs["zScore"] = zScore
+
+		switch s.direction {
+		case "high":
+			zScore = max(zScore, 0)
+		case "low":
+			zScore = min(zScore, 0)
+		}
+
+		status := result.StatusOK
+		if s.crit > 0 && math.Abs(zScore) >= s.crit {
+			status = result.StatusError
+		} else if s.warn > 0 && math.Abs(zScore) >= s.warn {
+			status = result.StatusWarning
+		}
+
+		if result.Severity(status) > result.Severity(res.Status) {
+			direction := "above"
+			if zScore < 0 {
+				direction = "below"
+			}
+
+			res.Status = status
+			res.ErrorClass = result.ErrorRule
+			res.Message = fmt.Sprintf("Anomaly detected: %s is %.1f sigma %s the baseline of %.2f", s.output, math.Abs(zScore), direction, b.mean)
+		}
+	}
+
+	if s.mode == anomalySeasonal {
+		b.add(x)
+	} else {
+		b.addEWMA(x, s.alpha)
+	}
+
+	return nil
+}
+
+// Build the baseline from stored results, so it isn't lost when the runner restarts.
+// Rolling baselines replay recent results, seasonal ones are summarised by the database
+func (m *Monitor) loadBaseline(db *database.DB) {
+	s, err := m.anomalySettings()
+	if err != nil {
+		log.Printf("Monitor '%s' has invalid anomaly settings: %v", m.Name, err)
+		return
+	}
+
+	m.anomaly = &anomalyState{}
+
+	if s.mode == anomalySeasonal {
+		m.loadSeasonalBaseline(db, s)
+		return
+	}
+
+	query := `
+		SELECT value, outputs
+		FROM results
+		WHERE monitor_id = $1 AND status != $2
+		ORDER BY date DESC
+		LIMIT 500
+	`
+
+	rows, err := db.Handle.Query(query, m.ID, result.StatusFailed)
+	if err != nil {
+		log.Printf("Failed to load baseline for monitor '%s': %v", m.Name, err)
+		return
+	}
+	defer rows.Close()
+
+	values := []float64{}
+
+	for rows.Next() {
+		var res result.Result
+
+		var outputs string
+
+		if err := rows.Scan(&res.Value, &outputs); err != nil {
+			log.Printf("Failed to load baseline for monitor '%s': %v", m.Name, err)
+			return
+		}
+
+		_ = json.Unmarshal([]byte(outputs), &res.Outputs)
+
+		if x, ok := newSample(&res).values[s.output]; ok {
+			values = append(values, x)
+		}
+	}
+
+	// Results are newest first, but the baseline is built oldest first
+	for i := len(values) - 1; i >= 0; i-- {
+		m.anomaly.rolling.addEWMA(values[i], s.alpha)
+	}
+}
+
+// Summarise the last four weeks of results into a baseline for each hour of the week
+func (m *Monitor) loadSeasonalBaseline(db *database.DB, s *anomalySettings) {
+	query := `
+		SELECT bucket, COUNT(*), AVG(x), COALESCE(VAR_POP(x), 0)
+		FROM (
+			SELECT (EXTRACT(DOW FROM date AT TIME ZONE 'UTC') * 24 + EXTRACT(HOUR FROM date AT TIME ZONE 'UTC'))::INT AS bucket,
+			CASE WHEN $2::TEXT = 'value' THEN value ELSE (outputs->>$2::TEXT)::FLOAT END AS x
+			FROM results
+			WHERE monitor_id = $1 AND status != $3 AND date > NOW() - INTERVAL '28 days'
+			AND ($2::TEXT = 'value' OR jsonb_typeof(outputs->$2::TEXT) = 'number')
+		) AS samples
+		GROUP BY bucket
+	`
+
+	rows, err := db.Handle.Query(query, m.ID, s.output, result.StatusFailed)
+	if err != nil {
+		log.Printf("Failed to load baseline for monitor '%s': %v", m.Name, err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bucket int
+
+		var b baseline
+
+		if err := rows.Scan(&bucket, &b.count, &b.mean, &b.variance); err != nil {
+			log.Printf("Failed to load baseline for monitor '%s': %v", m.Name, err)
+			return
+		}
+
+		if bucket >= 0 && bucket < len(m.anomaly.buckets) {
+			m.anomaly.buckets[bucket] = b
+		}
+	}
+}
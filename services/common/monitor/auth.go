@@ -0,0 +1,267 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon Runner - Authentication for the HTTP monitor
+// ----------------------------------------------------------------------------
+
+package monitor
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const AuthBasic = "basic"
+const AuthBearer = "bearer"
+const AuthAPIKey = "apiKey"
+const AuthAWSSigV4 = "awsSigV4"
+const AuthOAuth2 = "oauth2"
+
+// Properties used to fetch an OAuth2 token, when any of these change a new token is fetched
+var oauthTokenProps = []string{"authTokenUrl", "authClientId", "authClientSecret", "authScopes"}
+
+// OAuth2 access token cached between runs
+type oauthToken struct {
+	accessToken string
+	expiry      time.Time
+	key         string
+}
+
+// Add authentication to the request, based on the auth property. Must be called
+// after all other headers are set, as AWS signatures cover the headers
+func (m *Monitor) applyHTTPAuth(req *http.Request, client *httpClient, body string) error {
+	switch m.Properties["auth"] {
+	case "":
+		return nil
+
+	case AuthBasic:
+		req.SetBasicAuth(m.Properties["authUsername"], m.Properties["authPassword"])
+
+	case AuthBearer:
+		if m.Properties["authToken"] == "" {
+			return fmt.Errorf("auth: authToken is required for bearer auth")
+		}
+
+		req.Header.Set("Authorization", "Bearer "+m.Properties["authToken"])
+
+	case AuthAPIKey:
+		if m.Properties["authKey"] == "" {
+			return fmt.Errorf("auth: authKey is required for apiKey auth")
+		}
+
+		keyName := "X-API-Key"
+		if m.Properties["authKeyName"] != "" {
+			keyName = m.Properties["authKeyName"]
+		}
+
+		switch m.Properties["authKeyIn"] {
+		case "", "header":
+			req.Header.Set(keyName, m.Properties["authKey"])
+		case "query":
+			query := req.URL.Query()
+			query.Set(keyName, m.Properties["authKey"])
+			req.URL.RawQuery = query.Encode()
+		default:
+			return fmt.Errorf("auth: authKeyIn must be 'header' or 'query'")
+		}
+
+	case AuthAWSSigV4:
+		return signAWSv4(req, body, m.Properties, time.Now())
+
+	case AuthOAuth2:
+		token, err := m.getOAuthToken(client)
+		if err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+
+		req.Header.Set("Authorization", "Bearer "+token)
+
+	default:
+		return fmt.Errorf("auth: unsupported auth type '%s'", m.Properties["auth"])
+	}
+
+	return nil
+}
+
+// Get an OAuth2 access token using the client credentials grant, the token is
+// cached on the monitor and only fetched again when it is about to expire
+func (m *Monitor) getOAuthToken(client *httpClient) (string, error) {
+	key := ""
+	for _, prop := range oauthTokenProps {
+		key += prop + "=" + m.Properties[prop] + "\n"
+	}
+
+	// Refresh a little before the real expiry, so a token never expires mid request
+	if m.oauthToken != nil && m.oauthToken.key == key && time.Now().Add(30*time.Second).Before(m.oauthToken.expiry) {
+		return m.oauthToken.accessToken, nil
+	}
+
+	m.oauthToken = nil
+
+	if m.Properties["authTokenUrl"] == "" || m.Properties["authClientId"] == "" {
+		return "", fmt.Errorf("authTokenUrl and authClientId are required for oauth2 auth")
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", m.Properties["authClientId"])
+	form.Set("client_secret", m.Properties["authClientSecret"])
+
+	// Scopes can be given comma or space separated, OAuth2 wants them space separated
+	if m.Properties["authScopes"] != "" {
+		form.Set("scope", strings.Join(strings.FieldsFunc(m.Properties["authScopes"], func(r rune) bool {
+			return r == ',' || r == ' '
+		}), " "))
+	}
+
+	resp, err := client.PostForm(m.Properties["authTokenUrl"], form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed with status %d: %s", resp.StatusCode, respBody)
+	}
+
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+
+	err = json.Unmarshal(respBody, &tokenResp)
+	if err != nil {
+		return "", fmt.Errorf("token response: %w", err)
+	}
+
+	if tokenResp.AccessToken == "" {
+		return "", fmt.Errorf("token response has no access_token")
+	}
+
+	// Tokens without an expiry are not cached, as there's no way to know when to refresh them
+	m.oauthToken = &oauthToken{
+		accessToken: tokenResp.AccessToken,
+		expiry:      time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second),
+		key:         key,
+	}
+
+	return tokenResp.AccessToken, nil
+}
+
+// Sign the request with AWS Signature Version 4, see
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
+func signAWSv4(req *http.Request, body string, props map[string]string, now time.Time) error {
+	accessKey := props["authAccessKey"]
+	secretKey := props["authSecretKey"]
+	region := props["authRegion"]
+	service := props["authService"]
+
+	if accessKey == "" || secretKey == "" || region == "" || service == "" {
+		return fmt.Errorf("auth: authAccessKey, authSecretKey, authRegion and authService are required for awsSigV4 auth")
+	}
+
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	if props["authSessionToken"] != "" {
+		req.Header.Set("X-Amz-Security-Token", props["authSessionToken"])
+	}
+
+	// S3 requires the payload hash to be sent as a header
+	if service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	// Host isn't in the header map, so it is added here
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "host" || lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	headerNames := make([]string, 0, len(headers))
+	for name := range headers {
+		headerNames = append(headerNames, name)
+	}
+
+	sort.Strings(headerNames)
+
+	canonicalHeaders := ""
+	for _, name := range headerNames {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+
+	signedHeaders := strings.Join(headerNames, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, awsCanonicalQuery(req.URL.Query()), canonicalHeaders, signedHeaders, payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex(canonicalRequest)
+
+	signingKey := hmacSHA256([]byte("AWS4"+secretKey), date)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, service)
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+
+	return nil
+}
+
+// Query string sorted by key & value, encoded as AWS requires, with spaces as %20
+func awsCanonicalQuery(query url.Values) string {
+	params := []string{}
+
+	for key, values := range query {
+		for _, value := range values {
+			params = append(params, awsEscape(key)+"="+awsEscape(value))
+		}
+	}
+
+	sort.Strings(params)
+
+	return strings.Join(params, "&")
+}
+
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func sha256Hex(s string) string {
+	hash := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(hash[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,143 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon Runner - CEL rule engine, an alternative to govaluate with type checking
+// ----------------------------------------------------------------------------
+
+package monitor
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/ext"
+)
+
+// Rule engines, when none is set govaluate is used
+const RuleEngineGovaluate = "govaluate"
+const RuleEngineCEL = "cel"
+
+var RuleEngines = []string{RuleEngineGovaluate, RuleEngineCEL}
+
+// CEL environments are slow to create, so one is kept for each monitor type
+var (
+	celEnvs   = map[string]*cel.Env{}
+	celEnvsMu sync.Mutex
+)
+
+// Declared outputs are typed variables, all outputs can also be found in the outputs map
+// and the outputs of the previous run in the prev map
+func celEnv(monitorType string) (*cel.Env, error) {
+	celEnvsMu.Lock()
+	defer celEnvsMu.Unlock()
+
+	if env, ok := celEnvs[monitorType]; ok {
+		return env, nil
+	}
+
+	opts := []cel.EnvOption{
+		cel.CrossTypeNumericComparisons(true),
+		ext.Strings(),
+		ext.Math(),
+		cel.Variable("outputs", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("prev", cel.MapType(cel.StringType, cel.DynType)),
+	}
+
+	for name, def := range CommonOutputDefs {
+		opts = append(opts, cel.Variable(name, celType(def.Type)))
+	}
+
+	for name, def := range OutputDefs[monitorType] {
+		opts = append(opts, cel.Variable(name, celType(def.Type)))
+	}
+
+	env, err := cel.NewEnv(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	celEnvs[monitorType] = env
+
+	return env, nil
+}
+
+func celType(outputType string) *cel.Type {
+	switch outputType {
+	case OutputInt:
+		return cel.IntType
+	case OutputFloat:
+		return cel.DoubleType
+	case OutputString:
+		return cel.StringType
+	case OutputBool:
+		return cel.BoolType
+	default:
+		return cel.DynType
+	}
+}
+
+// Parse & type check a CEL rule, unknown outputs and rules not returning a bool are errors
+func NewCELRule(monitorType, rule string) (cel.Program, error) {
+	env, err := celEnv(monitorType)
+	if err != nil {
+		return nil, err
+	}
+
+	ast, issues := env.Compile(rule)
+	if issues.Err() != nil {
+		return nil, issues.Err()
+	}
+
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("rule must return a bool, not %s", ast.OutputType())
+	}
+
+	return env.Program(ast)
+}
+
+// Evaluate a CEL rule, outputs are converted to the declared types as CEL is strict about
+// ints & floats. Stored outputs have been through JSON so all numbers are floats
+func evalCELRule(prog cel.Program, monitorType string, params map[string]any) (any, error) {
+	outputs := make(map[string]any, len(params))
+	prev := map[string]any{}
+	vars := map[string]any{"outputs": outputs, "prev": prev}
+
+	for name, value := range params {
+		prevName, isPrev := strings.CutPrefix(name, prevPrefix)
+		if isPrev {
+			name = prevName
+		}
+
+		def, declared := outputDef(monitorType, name)
+
+		num, isNum := outputNumber(value)
+
+		switch {
+		case declared && def.Type == OutputInt && isNum:
+			value = int64(num)
+		case declared && def.Type == OutputString:
+			value = fmt.Sprint(value)
+		case isNum:
+			value = num
+		}
+
+		if isPrev {
+			prev[name] = value
+			continue
+		}
+
+		outputs[name] = value
+
+		if declared {
+			vars[name] = value
+		}
+	}
+
+	out, _, err := prog.Eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Value(), nil
+}
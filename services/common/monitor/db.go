@@ -16,7 +16,7 @@ func FetchMonitors(db *database.DB) ([]*Monitor, error) {
 
 	query := `
 		SELECT id, name, type, interval, updated, enabled, 
-		rule, target, properties FROM monitors
+		rule, warn_rule, crit_rule, rule_engine, value_unit, value_precision, target, properties FROM monitors
 	`
 
 	rows, err := db.Handle.Query(query)
@@ -31,7 +31,7 @@ func FetchMonitors(db *database.DB) ([]*Monitor, error) {
 		var properties string
 
 		if err := rows.Scan(&m.ID, &m.Name, &m.Type, &m.Interval, &m.Updated, &m.Enabled,
-			&m.Rule, &m.Target, &properties); err != nil {
+			&m.Rule, &m.WarnRule, &m.CritRule, &m.RuleEngine, &m.Unit, &m.Precision, &m.Target, &properties); err != nil {
 			return nil, err
 		}
 
@@ -52,14 +52,16 @@ func (m *Monitor) Store(db *database.DB) error {
 
 	// Need to use RETURNING to get the ID back
 	query := `
-		INSERT INTO monitors (name, type, interval, target, rule, enabled, properties)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO monitors (name, type, interval, target, rule, warn_rule, crit_rule, rule_engine, value_unit, value_precision,
+		enabled, properties)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
 		RETURNING id
 	`
 
 	var id int
 
-	err = db.Handle.QueryRow(query, m.Name, m.Type, m.Interval, m.Target, m.Rule, m.Enabled, string(properties)).Scan(&id)
+	err = db.Handle.QueryRow(query, m.Name, m.Type, m.Interval, m.Target, m.Rule, m.WarnRule, m.CritRule, m.RuleEngine,
+		m.Unit, m.Precision, m.Enabled, string(properties)).Scan(&id)
 	if err != nil {
 		return err
 	}
@@ -76,9 +78,13 @@ func FetchMonitor(db *database.DB, id int) (*Monitor, error) {
 
 	var properties string
 
-	query := "SELECT id, name, type, interval, updated, enabled, rule, target, properties FROM monitors WHERE id = $1"
+	query := `
+		SELECT id, name, type, interval, updated, enabled,
+		rule, warn_rule, crit_rule, rule_engine, value_unit, value_precision, target, properties FROM monitors WHERE id = $1
+	`
 
-	err := db.Handle.QueryRow(query, id).Scan(&m.ID, &m.Name, &m.Type, &m.Interval, &m.Updated, &m.Enabled, &m.Rule, &m.Target, &properties)
+	err := db.Handle.QueryRow(query, id).Scan(&m.ID, &m.Name, &m.Type, &m.Interval, &m.Updated, &m.Enabled,
+		&m.Rule, &m.WarnRule, &m.CritRule, &m.RuleEngine, &m.Unit, &m.Precision, &m.Target, &properties)
 	if err != nil {
 		return nil, err
 	}
@@ -115,14 +121,16 @@ func (m *Monitor) Update(db *database.DB) error {
 
 	query := `
 		UPDATE monitors
-		SET name = $1, type = $2, interval = $3, target = $4, rule = $5, enabled = $6, properties = $7
-		WHERE id = $8
+		SET name = $1, type = $2, interval = $3, target = $4, rule = $5, warn_rule = $6, crit_rule = $7,
+		rule_engine = $8, value_unit = $9, value_precision = $10, enabled = $11, properties = $12
+		WHERE id = $13
 		RETURNING id
 	`
 
 	var id int
 
-	err = db.Handle.QueryRow(query, m.Name, m.Type, m.Interval, m.Target, m.Rule, m.Enabled, string(properties), m.ID).Scan(&id)
+	err = db.Handle.QueryRow(query, m.Name, m.Type, m.Interval, m.Target, m.Rule, m.WarnRule, m.CritRule, m.RuleEngine,
+		m.Unit, m.Precision, m.Enabled, string(properties), m.ID).Scan(&id)
 	if err != nil {
 		return err
 	}
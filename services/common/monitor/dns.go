@@ -16,7 +16,7 @@ import (
 )
 
 func (m *Monitor) runDNS() *result.Result {
-	log.Printf("Running DNS monitor '%s' on target %s", m.Name, m.Target)
+	log.Printf("Running DNS monitor '%s' on target %s", m.Name, RedactTarget(m.Target))
 	r := result.NewResult(m.Name, m.Target, m.ID)
 
 	var err error
@@ -30,7 +30,7 @@ func (m *Monitor) runDNS() *result.Result {
 	if timeoutProp != "" {
 		timeout, err = time.ParseDuration(timeoutProp)
 		if err != nil {
-			return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+			return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
 		}
 	}
 
@@ -121,13 +121,13 @@ func (m *Monitor) runDNS() *result.Result {
 		}
 
 	default:
-		return result.NewFailedResult(m.Name, m.Target, m.ID, fmt.Errorf("invalid record type: %s", recordType))
+		return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(fmt.Errorf("invalid record type: %s", recordType)))
 	}
 
-	r.Value = int(time.Since(start).Milliseconds())
+	r.Value = durationMs(time.Since(start))
 
 	outputs := map[string]interface{}{
-		"respTime":    r.Value,
+		"respTime":    int(r.Value),
 		"resultCount": len(results),
 	}
 
@@ -0,0 +1,251 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon Runner - Domain registration monitor implementation, using RDAP
+// ----------------------------------------------------------------------------
+
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"nanomon/services/common/result"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// IANA bootstrap file mapping TLDs to RDAP servers, see RFC 9224
+var rdapBootstrapURL = "https://data.iana.org/rdap/dns.json"
+
+// How long the bootstrap file is cached before being fetched again
+const rdapBootstrapTTL = 24 * time.Hour
+
+// Cached copy of the bootstrap data, shared by all domain monitors
+var rdapBootstrap = struct {
+	sync.Mutex
+	services map[string]string
+	fetched  time.Time
+}{}
+
+// Subset of the RDAP domain object we care about, see RFC 9083
+type rdapDomain struct {
+	LDHName string   `json:"ldhName"`
+	Status  []string `json:"status"`
+	Events  []struct {
+		Action string `json:"eventAction"`
+		Date   string `json:"eventDate"`
+	} `json:"events"`
+	Entities []struct {
+		Roles      []string `json:"roles"`
+		VCardArray []any    `json:"vcardArray"`
+	} `json:"entities"`
+	Nameservers []struct {
+		LDHName string `json:"ldhName"`
+	} `json:"nameservers"`
+}
+
+func (m *Monitor) runDomain() *result.Result {
+	r := result.NewResult(m.Name, m.Target, m.ID)
+
+	var err error
+
+	timeout := time.Duration(10) * time.Second
+
+	timeoutProp := m.Properties["timeout"]
+	if timeoutProp != "" {
+		timeout, err = time.ParseDuration(timeoutProp)
+		if err != nil {
+			return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
+		}
+	}
+
+	// Target can be any hostname or URL, we only want the registrable domain
+	host := m.Target
+	if u, err := url.Parse(m.Target); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+
+	domain, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(strings.TrimSuffix(host, ".")))
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
+	}
+
+	client := http.Client{
+		Timeout: timeout,
+	}
+
+	server := m.Properties["server"]
+	if server == "" {
+		server, err = lookupRDAPServer(&client, domain)
+		if err != nil {
+			return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+		}
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(server, "/")+"/domain/"+domain, nil)
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
+	}
+
+	req.Header.Add("Accept", "application/rdap+json")
+
+	start := time.Now()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+	}
+	defer resp.Body.Close()
+
+	r.Value = durationMs(time.Since(start))
+
+	if resp.StatusCode == http.StatusNotFound {
+		err = fmt.Errorf("domain %s not found in RDAP", domain)
+		return result.NewFailedResult(m.Name, m.Target, m.ID, result.WithClass(result.ErrorHTTPStatus, err))
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("RDAP server returned status %d", resp.StatusCode)
+		return result.NewFailedResult(m.Name, m.Target, m.ID, result.WithClass(result.ErrorHTTPStatus, err))
+	}
+
+	var rdap rdapDomain
+
+	err = json.NewDecoder(resp.Body).Decode(&rdap)
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+	}
+
+	nameservers := []string{}
+	for _, ns := range rdap.Nameservers {
+		nameservers = append(nameservers, strings.ToLower(ns.LDHName))
+	}
+
+	outputs := map[string]any{
+		"respTime":        int(r.Value),
+		"domain":          domain,
+		"registrar":       "",
+		"status":          strings.Join(rdap.Status, ", "),
+		"nameservers":     strings.Join(nameservers, ", "),
+		"nameserverCount": len(nameservers),
+	}
+
+	for _, ev := range rdap.Events {
+		if ev.Action != "expiration" {
+			continue
+		}
+
+		expires, err := time.Parse(time.RFC3339, ev.Date)
+		if err != nil {
+			return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+		}
+
+		outputs["expiryDate"] = expires.UTC().Format(time.RFC3339)
+		outputs["expiryDays"] = int(time.Until(expires).Hours() / 24)
+	}
+
+	for _, ent := range rdap.Entities {
+		for _, role := range ent.Roles {
+			if role == "registrar" {
+				outputs["registrar"] = vcardName(ent.VCardArray)
+			}
+		}
+	}
+
+	r.Outputs = outputs
+
+	return r
+}
+
+// Find the RDAP base URL for a domain using the IANA bootstrap file
+func lookupRDAPServer(client *http.Client, domain string) (string, error) {
+	rdapBootstrap.Lock()
+	defer rdapBootstrap.Unlock()
+
+	if rdapBootstrap.services == nil || time.Since(rdapBootstrap.fetched) > rdapBootstrapTTL {
+		resp, err := client.Get(rdapBootstrapURL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("RDAP bootstrap returned status %d", resp.StatusCode)
+		}
+
+		// Services are a list of pairs, the first item is a list of TLDs the second a list of URLs
+		var bootstrap struct {
+			Services [][][]string `json:"services"`
+		}
+
+		err = json.NewDecoder(resp.Body).Decode(&bootstrap)
+		if err != nil {
+			return "", err
+		}
+
+		services := map[string]string{}
+
+		for _, svc := range bootstrap.Services {
+			if len(svc) < 2 || len(svc[1]) == 0 {
+				continue
+			}
+
+			// Prefer HTTPS URLs where a registry lists more than one
+			svcURL := svc[1][0]
+
+			for _, u := range svc[1] {
+				if strings.HasPrefix(u, "https://") {
+					svcURL = u
+					break
+				}
+			}
+
+			for _, tld := range svc[0] {
+				services[strings.ToLower(tld)] = svcURL
+			}
+		}
+
+		rdapBootstrap.services = services
+		rdapBootstrap.fetched = time.Now()
+	}
+
+	// Longest match wins, so try the full domain first and work down to the TLD
+	labels := strings.Split(domain, ".")
+	for i := range labels {
+		if svcURL, ok := rdapBootstrap.services[strings.Join(labels[i:], ".")]; ok {
+			return svcURL, nil
+		}
+	}
+
+	return "", fmt.Errorf("no RDAP server found for domain %s", domain)
+}
+
+// Pull the formatted name out of a jCard, see RFC 7095
+func vcardName(vcard []any) string {
+	if len(vcard) < 2 {
+		return ""
+	}
+
+	props, ok := vcard[1].([]any)
+	if !ok {
+		return ""
+	}
+
+	for _, p := range props {
+		prop, ok := p.([]any)
+		if !ok || len(prop) < 4 {
+			continue
+		}
+
+		if name, ok := prop[0].(string); ok && name == "fn" {
+			value, _ := prop[3].(string)
+			return value
+		}
+	}
+
+	return ""
+}
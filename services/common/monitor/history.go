@@ -0,0 +1,275 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon Runner - History of recent results, for rules over windows of runs
+// ----------------------------------------------------------------------------
+
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"nanomon/services/common/database"
+	"nanomon/services/common/result"
+	"regexp"
+	"slices"
+
+	"github.com/Knetic/govaluate"
+)
+
+// Most results kept per monitor, which is also the largest window a rule can use
+const historySize = 100
+
+// Finds the output name passed to a history function or changed(), quoted strings are matched so they can be skipped
+var historyArgRegex = regexp.MustCompile(`'[^']*'|"[^"]*"|\b(avg|p50|p90|p95|p99|delta|changed)\s*\(\s*([A-Za-z_][A-Za-z0-9_]*)\s*([,)])`)
+
+// Any call to a history function
+var historyFuncRegex = regexp.MustCompile(`\b(avg|p50|p90|p95|p99|delta|failures)\s*\(`)
+
+// The numeric values & status of a single run of a monitor
+type sample struct {
+	status int
+	values map[string]float64
+}
+
+// Ring buffer of the most recent samples
+type history struct {
+	samples []sample
+	next    int
+	count   int
+}
+
+// Create a sample from a result, the result value is included as 'value'
+func newSample(res *result.Result) sample {
+	s := sample{
+		status: res.Status,
+		values: map[string]float64{"value": res.Value},
+	}
+
+	for name, output := range res.Outputs {
+		if v, ok := outputNumber(output); ok {
+			s.values[name] = v
+		}
+	}
+
+	return s
+}
+
+func (h *history) add(s sample) {
+	if h.samples == nil {
+		h.samples = make([]sample, historySize)
+	}
+
+	h.samples[h.next] = s
+	h.next = (h.next + 1) % historySize
+	h.count = min(h.count+1, historySize)
+}
+
+// The most recent n samples, oldest first
+func (h *history) last(n int) []sample {
+	n = min(n, h.count)
+	out := make([]sample, 0, n+1)
+
+	for i := n; i > 0; i-- {
+		out = append(out, h.samples[(h.next-i+historySize)%historySize])
+	}
+
+	return out
+}
+
+// Rule functions which look back over recent runs, the current run is always the last
+// sample in the window. For the API the history is empty, so only the current run is used
+func (h *history) functions(current sample) map[string]govaluate.ExpressionFunction {
+	window := func(name string, args []any, withName bool) (string, []sample, error) {
+		want := 1
+		if withName {
+			want = 2
+		}
+
+		if len(args) != want {
+			return "", nil, fmt.Errorf("%s() takes %d arguments", name, want)
+		}
+
+		nums, err := ruleNumbers(name, args[want-1:], 1, 1)
+		if err != nil {
+			return "", nil, err
+		}
+
+		size := int(nums[0])
+		if size < 1 || size > historySize {
+			return "", nil, fmt.Errorf("%s() window must be between 1 and %d", name, historySize)
+		}
+
+		output := ""
+		if withName {
+			output = fmt.Sprint(args[0])
+		}
+
+		return output, append(h.last(size-1), current), nil
+	}
+
+	percentile := func(name string, p float64) govaluate.ExpressionFunction {
+		return func(args ...any) (any, error) {
+			output, samples, err := window(name, args, true)
+			if err != nil {
+				return nil, err
+			}
+
+			vals, err := sampleValues(name, output, samples)
+			if err != nil {
+				return nil, err
+			}
+
+			// Nearest rank method, so the result is always one of the values
+			slices.Sort(vals)
+			rank := int(math.Ceil(p / 100 * float64(len(vals))))
+
+			return vals[max(rank, 1)-1], nil
+		}
+	}
+
+	return map[string]govaluate.ExpressionFunction{
+		"avg": func(args ...any) (any, error) {
+			output, samples, err := window("avg", args, true)
+			if err != nil {
+				return nil, err
+			}
+
+			vals, err := sampleValues("avg", output, samples)
+			if err != nil {
+				return nil, err
+			}
+
+			total := 0.0
+			for _, v := range vals {
+				total += v
+			}
+
+			return total / float64(len(vals)), nil
+		},
+		"p50": percentile("p50", 50),
+		"p90": percentile("p90", 90),
+		"p95": percentile("p95", 95),
+		"p99": percentile("p99", 99),
+		"failures": func(args ...any) (any, error) {
+			_, samples, err := window("failures", args, false)
+			if err != nil {
+				return nil, err
+			}
+
+			count := 0.0
+
+			for _, s := range samples {
+				if s.status == result.StatusError || s.status == result.StatusFailed {
+					count++
+				}
+			}
+
+			return count, nil
+		},
+		"delta": func(args ...any) (any, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("delta() takes 1 argument")
+			}
+
+			output := fmt.Sprint(args[0])
+
+			now, ok := current.values[output]
+			if !ok {
+				return nil, fmt.Errorf("delta(): '%s' is not a number output", output)
+			}
+
+			// Compare with the most recent run which had the output
+			prev := h.last(historySize)
+			for i := len(prev) - 1; i >= 0; i-- {
+				if v, ok := prev[i].values[output]; ok {
+					return now - v, nil
+				}
+			}
+
+			return 0.0, nil
+		},
+	}
+}
+
+// Values of the named output from the samples, runs without the output are skipped
+func sampleValues(name, output string, samples []sample) ([]float64, error) {
+	vals := []float64{}
+
+	for _, s := range samples {
+		if v, ok := s.values[output]; ok {
+			vals = append(vals, v)
+		}
+	}
+
+	if len(vals) == 0 {
+		return nil, fmt.Errorf("%s(): '%s' is not a number output", name, output)
+	}
+
+	return vals, nil
+}
+
+// Output names given to history functions are quoted, so the function gets the name
+// rather than the current value, e.g. avg(respTime, 5) becomes avg('respTime', 5)
+func quoteHistoryArgs(rule string) string {
+	return historyArgRegex.ReplaceAllStringFunc(rule, func(match string) string {
+		parts := historyArgRegex.FindStringSubmatch(match)
+		if parts[1] == "" {
+			return match
+		}
+
+		return fmt.Sprintf("%s('%s'%s", parts[1], parts[2], parts[3])
+	})
+}
+
+// Check if any of the rules use functions which need the history of results
+func usesHistory(rules ...string) bool {
+	for _, rule := range rules {
+		if historyFuncRegex.MatchString(rule) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Seed the history from the most recent stored results, so windows survive restarts
+func (m *Monitor) loadHistory(db *database.DB) {
+	query := `
+		SELECT status, value, outputs
+		FROM results
+		WHERE monitor_id = $1
+		ORDER BY date DESC
+		LIMIT $2
+	`
+
+	rows, err := db.Handle.Query(query, m.ID, historySize)
+	if err != nil {
+		log.Printf("Failed to load history for monitor '%s': %v", m.Name, err)
+		return
+	}
+	defer rows.Close()
+
+	samples := []sample{}
+
+	for rows.Next() {
+		var res result.Result
+
+		var outputs string
+
+		if err := rows.Scan(&res.Status, &res.Value, &outputs); err != nil {
+			log.Printf("Failed to load history for monitor '%s': %v", m.Name, err)
+			return
+		}
+
+		_ = json.Unmarshal([]byte(outputs), &res.Outputs)
+
+		samples = append(samples, newSample(&res))
+	}
+
+	// Results are newest first, but the history is filled oldest first
+	for i := len(samples) - 1; i >= 0; i-- {
+		m.history.add(samples[i])
+	}
+}
@@ -6,54 +6,83 @@
 package monitor
 
 import (
-	"crypto/tls"
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"nanomon/services/common/result"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/tidwall/gjson"
 )
 
 func (m *Monitor) runHTTP() *result.Result {
 	r := result.NewResult(m.Name, m.Target, m.ID)
 
-	var err error
-
 	method := "GET"
-	timeout := time.Duration(5) * time.Second
-	validateTLS := true
 
 	methodProp := m.Properties["method"]
 	if methodProp != "" {
 		method = strings.ToUpper(methodProp)
 	}
 
-	timeoutProp := m.Properties["timeout"]
-	if timeoutProp != "" {
-		timeout, err = time.ParseDuration(timeoutProp)
+	// Zero reads the whole body, as before maxBodySize was added
+	maxBodySize := int64(0)
+
+	maxBodySizeProp := m.Properties["maxBodySize"]
+	if maxBodySizeProp != "" {
+		size, err := parseByteSize(maxBodySizeProp)
 		if err != nil {
-			return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+			return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(fmt.Errorf("maxBodySize: %w", err)))
 		}
+
+		maxBodySize = size
 	}
 
-	validateTLSProp := m.Properties["validateTLS"]
-	if validateTLSProp != "" {
-		validateTLS, err = strconv.ParseBool(validateTLSProp)
+	// Compiled before the request is made, so a bad regex fails fast
+	var bodyRegex *regexp.Regexp
+
+	if m.Properties["bodyRegex"] != "" {
+		re, err := regexp.Compile(m.Properties["bodyRegex"])
 		if err != nil {
-			return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+			return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
 		}
+
+		bodyRegex = re
 	}
 
-	req, err := http.NewRequest(method, m.Target, nil)
+	client, err := m.getHTTPClient()
 	if err != nil {
-		return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+		return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
+	}
+
+	// Templates are rendered fresh every run, so values like timestamps & nonces change
+	tmplData := httpTemplateData{}
+
+	tmplData.Body, err = renderHTTPTemplate("body", m.Properties["body"], tmplData)
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
+	}
+
+	targetURL, err := renderHTTPTemplate("url", m.Target, tmplData)
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
 	}
 
-	if m.Properties["body"] != "" {
-		req.Body = io.NopCloser(strings.NewReader(m.Properties["body"]))
+	var reqBody io.Reader
+	if tmplData.Body != "" {
+		reqBody = strings.NewReader(tmplData.Body)
+	}
+
+	req, err := http.NewRequest(method, targetURL, reqBody)
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
 	}
 
 	if m.Properties["headers"] != "" {
@@ -61,11 +90,16 @@ func (m *Monitor) runHTTP() *result.Result {
 
 		err = json.Unmarshal([]byte(m.Properties["headers"]), &headers)
 		if err != nil {
-			return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+			return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
 		}
 
 		for k, v := range headers {
-			req.Header.Add(k, v)
+			value, err := renderHTTPTemplate("header "+k, v, tmplData)
+			if err != nil {
+				return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
+			}
+
+			req.Header.Add(k, value)
 		}
 	}
 
@@ -74,12 +108,14 @@ func (m *Monitor) runHTTP() *result.Result {
 		req.Header.Add("User-Agent", userAgent)
 	}
 
-	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: !validateTLS}
-
-	client := http.Client{
-		Timeout: timeout,
+	err = m.applyHTTPAuth(req, client, tmplData.Body)
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, err)
 	}
 
+	trace, clientTrace := newHTTPTrace(client)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), clientTrace))
+
 	start := time.Now()
 
 	resp, err := client.Do(req)
@@ -88,34 +124,77 @@ func (m *Monitor) runHTTP() *result.Result {
 	}
 	defer resp.Body.Close()
 
-	r.Value = int(time.Since(start).Milliseconds())
+	// Token might have been revoked, so get a fresh one next time
+	if resp.StatusCode == http.StatusUnauthorized {
+		m.oauthToken = nil
+	}
+
+	r.Value = durationMs(time.Since(start))
 
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
+	if m.Properties["httpVersion"] == "2" && resp.ProtoMajor != 2 {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, fmt.Errorf("server did not use HTTP/2, got %s", resp.Proto))
+	}
+
+	// Read the body up to the max size, reading one extra byte tells if there's more
+	// Anything beyond the max size is never read, the connection is simply closed
+	var bodyReader io.Reader = resp.Body
+	if maxBodySize > 0 {
+		bodyReader = io.LimitReader(resp.Body, maxBodySize+1)
+	}
+
+	body, err := io.ReadAll(bodyReader)
 	if err != nil {
 		return result.NewFailedResult(m.Name, m.Target, m.ID, err)
 	}
 
-	bodyStr := string(body)
-	regexMatch := ""
+	end := time.Now()
 
-	if m.Properties["bodyRegex"] != "" {
-		re, err := regexp.Compile(m.Properties["bodyRegex"])
-		if err != nil {
-			return result.NewFailedResult(m.Name, m.Target, m.ID, err)
-		}
+	truncated := maxBodySize > 0 && int64(len(body)) > maxBodySize
+	if truncated {
+		body = body[:maxBodySize]
+	}
 
-		match := re.FindStringSubmatch(bodyStr)
+	regexMatch := ""
+
+	if bodyRegex != nil {
+		match := bodyRegex.FindSubmatch(body)
 		if len(match) > 1 {
-			regexMatch = match[1]
+			regexMatch = string(match[1])
 		}
 	}
 
+	bodyStr := string(body)
+
 	outputs := map[string]any{
-		"body":     bodyStr,
-		"bodyLen":  len(body),
-		"status":   resp.StatusCode,
-		"respTime": r.Value,
+		"bodyLen":   len(body),
+		"truncated": truncated,
+		"status":    resp.StatusCode,
+		"respTime":  int(r.Value),
+		"protocol":  resp.Proto,
+		"body":      bodyStr,
+	}
+
+	trace.addOutputs(outputs, end)
+
+	// Each request made by following a redirect links back to the response which caused it
+	redirectCount := 0
+	for req := resp.Request; req.Response != nil; req = req.Response.Request {
+		redirectCount++
+	}
+
+	outputs["redirectCount"] = redirectCount
+	outputs["finalUrl"] = redactURL(resp.Request.URL)
+
+	// Header names are turned into outputs like headerCacheControl, so they can be used in rules
+	if m.Properties["captureHeaders"] != "" {
+		for _, name := range strings.Split(m.Properties["captureHeaders"], ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+
+			outputs["header"+strings.ReplaceAll(http.CanonicalHeaderKey(name), "-", "")] = resp.Header.Get(name)
+		}
 	}
 
 	// If the regex match is a number, convert it to a float
@@ -125,11 +204,42 @@ func (m *Monitor) runHTTP() *result.Result {
 
 		// SPECIAL: When the regex match is a number,
 		// - set the result value to the number, this is a special case
-		r.Value = int(regexMatchFloat)
+		r.Value = regexMatchFloat
 	} else {
 		outputs["regexMatch"] = regexMatch
 	}
 
+	if m.Properties["detectChanges"] == "true" {
+		err = m.detectChanges(bodyStr, outputs)
+		if err != nil {
+			return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+		}
+	}
+
+	if m.Properties["jsonPaths"] != "" {
+		if truncated {
+			err = fmt.Errorf("jsonPaths: response body is larger than maxBodySize")
+			return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
+		}
+
+		err = extractJSONPaths(body, m.Properties["jsonPaths"], outputs)
+		if err != nil {
+			return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+		}
+	}
+
+	// Expectations are checked here, so any rule can still be evaluated on top of them
+	msg, errorClass, err := checkHTTPExpectations(m.Properties, resp.StatusCode, body)
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
+	}
+
+	if msg != "" {
+		r.Status = result.StatusError
+		r.Message = msg
+		r.ErrorClass = errorClass
+	}
+
 	// Get cert expiry if it is a TLS connection and the cert exists
 	if resp.TLS != nil {
 		cert := resp.TLS.PeerCertificates[0]
@@ -138,6 +248,14 @@ func (m *Monitor) runHTTP() *result.Result {
 			days := int(time.Until(expires).Hours() / 24)
 			outputs["certExpiryDays"] = days
 		}
+
+		outputs["clientCertRequested"] = client.certRequested.Load()
+		outputs["clientCertPresented"] = client.certRequested.Load() && client.clientCert != nil
+
+		if client.clientCert != nil {
+			outputs["clientCertSubject"] = client.clientCert.Subject.String()
+			outputs["clientCertExpiryDays"] = int(time.Until(client.clientCert.NotAfter).Hours() / 24)
+		}
 	}
 
 	// Save all outputs
@@ -145,3 +263,167 @@ func (m *Monitor) runHTTP() *result.Result {
 
 	return r
 }
+
+// Extract values from a JSON body into the outputs, paths are given as a JSON object
+// mapping output names to gjson paths, e.g. {"dbStatus": "db.status"}
+func extractJSONPaths(body []byte, pathsProp string, outputs map[string]any) error {
+	var paths map[string]string
+
+	err := json.Unmarshal([]byte(pathsProp), &paths)
+	if err != nil {
+		return result.ConfigError(fmt.Errorf("jsonPaths: %w", err))
+	}
+
+	if !gjson.ValidBytes(body) {
+		return fmt.Errorf("jsonPaths: response body is not valid JSON")
+	}
+
+	for name, path := range paths {
+		// Allow JSONPath style paths starting with '$.' as they are a common habit
+		value := gjson.GetBytes(body, strings.TrimPrefix(path, "$."))
+		if !value.Exists() {
+			continue
+		}
+
+		// Objects & arrays are kept as raw JSON strings, as rules can't use them directly
+		switch value.Type {
+		case gjson.JSON:
+			outputs[name] = value.Raw
+		case gjson.Null:
+			outputs[name] = nil
+		default:
+			outputs[name] = value.Value()
+		}
+	}
+
+	return nil
+}
+
+// Names of jsonPaths outputs, so they can be used as variables in rules
+var outputNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Check the jsonPaths property when a monitor is saved, names must be usable in rules and
+// can't replace the outputs the HTTP monitor already returns
+func ValidateJSONPaths(pathsProp string) error {
+	var paths map[string]string
+
+	err := json.Unmarshal([]byte(pathsProp), &paths)
+	if err != nil {
+		return fmt.Errorf("jsonPaths: %w", err)
+	}
+
+	for name := range paths {
+		if !outputNameRegex.MatchString(name) {
+			return fmt.Errorf("jsonPaths: '%s' is not a valid output name", name)
+		}
+
+		if _, exists := outputDef(TypeHTTP, name); exists || name == "prev" {
+			return fmt.Errorf("jsonPaths: '%s' is already an output", name)
+		}
+	}
+
+	return nil
+}
+
+// The URL without the query string or user info, as they can hold credentials e.g. authKeyIn=query
+func redactURL(u *url.URL) string {
+	safe := url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path, RawPath: u.RawPath}
+
+	return safe.String()
+}
+
+// Largest body which can be read, the body is held in memory for the regex, JSON & rules
+const maxBodySizeLimit = 100 << 20
+
+// Parse a size in bytes, with an optional KB, MB or GB suffix, e.g. "512KB", up to maxBodySizeLimit
+func parseByteSize(size string) (int64, error) {
+	size = strings.ToUpper(strings.TrimSpace(size))
+	multiplier := int64(1)
+
+	for i, suffix := range []string{"KB", "MB", "GB"} {
+		if numStr, ok := strings.CutSuffix(size, suffix); ok {
+			size = strings.TrimSpace(numStr)
+			multiplier = 1 << (10 * (i + 1))
+
+			break
+		}
+	}
+
+	num, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || num < 1 {
+		return 0, fmt.Errorf("invalid size '%s'", size)
+	}
+
+	// Checked before multiplying, so huge values can't overflow
+	if num > maxBodySizeLimit/multiplier {
+		return 0, fmt.Errorf("size '%s' is over the limit of 100MB", size)
+	}
+
+	return num * multiplier, nil
+}
+
+// Check the expectStatus, expectKeyword and expectNoKeyword properties against the response,
+// returns a message & error class for the first one which isn't met, or empty if all are met
+func checkHTTPExpectations(props map[string]string, status int, body []byte) (string, string, error) {
+	if props["expectStatus"] != "" {
+		ok, err := statusMatches(props["expectStatus"], status)
+		if err != nil {
+			return "", "", fmt.Errorf("expectStatus: %w", err)
+		}
+
+		if !ok {
+			return fmt.Sprintf("status %d not in %s", status, props["expectStatus"]), result.ErrorHTTPStatus, nil
+		}
+	}
+
+	if props["expectKeyword"] != "" && !bytes.Contains(body, []byte(props["expectKeyword"])) {
+		return fmt.Sprintf("keyword '%s' not found in body", props["expectKeyword"]), result.ErrorRule, nil
+	}
+
+	if props["expectNoKeyword"] != "" && bytes.Contains(body, []byte(props["expectNoKeyword"])) {
+		return fmt.Sprintf("keyword '%s' found in body", props["expectNoKeyword"]), result.ErrorRule, nil
+	}
+
+	return "", "", nil
+}
+
+// Check a status code against a comma separated list of codes, classes like
+// 2xx and ranges like 200-204, e.g. "2xx,301,400-404". The whole list is
+// always checked, so a mistake is reported even when an earlier part matches
+func statusMatches(expect string, status int) (bool, error) {
+	matched := false
+
+	for _, part := range strings.Split(expect, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+
+		if class, ok := strings.CutSuffix(part, "xx"); ok {
+			digit, err := strconv.Atoi(class)
+			if err != nil || digit < 1 || digit > 5 {
+				return false, fmt.Errorf("invalid status class '%s'", part)
+			}
+
+			matched = matched || status/100 == digit
+
+			continue
+		}
+
+		lowStr, highStr, isRange := strings.Cut(part, "-")
+		if !isRange {
+			highStr = lowStr
+		}
+
+		low, err := strconv.Atoi(strings.TrimSpace(lowStr))
+		if err != nil {
+			return false, fmt.Errorf("invalid status '%s'", part)
+		}
+
+		high, err := strconv.Atoi(strings.TrimSpace(highStr))
+		if err != nil {
+			return false, fmt.Errorf("invalid status '%s'", part)
+		}
+
+		matched = matched || (status >= low && status <= high)
+	}
+
+	return matched, nil
+}
@@ -0,0 +1,244 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon Runner - HTTP client & transport, built per monitor from properties
+// ----------------------------------------------------------------------------
+
+package monitor
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Properties which affect the client, when any of these change the client is rebuilt
+var httpClientProps = []string{
+	"timeout", "validateTLS", "caCert", "proxy", "keepAlive", "httpVersion", "source", "clientCert", "clientKey",
+	"followRedirects", "maxRedirects",
+}
+
+// Wraps the standard HTTP client with state about the TLS client certificate
+type httpClient struct {
+	*http.Client
+
+	key           string            // Built from the properties used to create the client
+	clientCert    *x509.Certificate // Client certificate for mTLS, if one is configured
+	certRequested atomic.Bool       // Set when the server asks for a client certificate
+}
+
+// Get the HTTP client for this monitor, it is built once then cached. Each monitor
+// has its own transport so settings like validateTLS never leak between monitors
+func (m *Monitor) getHTTPClient() (*httpClient, error) {
+	key := ""
+	for _, prop := range httpClientProps {
+		key += prop + "=" + m.Properties[prop] + "\n"
+	}
+
+	m.httpClientMu.Lock()
+	defer m.httpClientMu.Unlock()
+
+	if m.httpClient != nil && m.httpClient.key == key {
+		return m.httpClient, nil
+	}
+
+	client, err := m.newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+
+	if m.httpClient != nil {
+		m.httpClient.CloseIdleConnections()
+	}
+
+	client.key = key
+	m.httpClient = client
+
+	return client, nil
+}
+
+// Build a new HTTP client from the monitor properties
+func (m *Monitor) newHTTPClient() (*httpClient, error) {
+	var err error
+
+	timeout := time.Duration(5) * time.Second
+	validateTLS := true
+	keepAlive := true
+	followRedirects := true
+	maxRedirects := 10
+
+	timeoutProp := m.Properties["timeout"]
+	if timeoutProp != "" {
+		timeout, err = time.ParseDuration(timeoutProp)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	validateTLSProp := m.Properties["validateTLS"]
+	if validateTLSProp != "" {
+		validateTLS, err = strconv.ParseBool(validateTLSProp)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	keepAliveProp := m.Properties["keepAlive"]
+	if keepAliveProp != "" {
+		keepAlive, err = strconv.ParseBool(keepAliveProp)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	followRedirectsProp := m.Properties["followRedirects"]
+	if followRedirectsProp != "" {
+		followRedirects, err = strconv.ParseBool(followRedirectsProp)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	maxRedirectsProp := m.Properties["maxRedirects"]
+	if maxRedirectsProp != "" {
+		maxRedirects, err = strconv.Atoi(maxRedirectsProp)
+		if err != nil || maxRedirects < 0 {
+			return nil, fmt.Errorf("maxRedirects: must be a positive number")
+		}
+	}
+
+	// Start from a copy of the default transport, to get sensible defaults
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableKeepAlives = !keepAlive
+	transport.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: !validateTLS,
+	}
+
+	if m.Properties["caCert"] != "" {
+		transport.TLSClientConfig.RootCAs, err = loadCACerts(m.Properties["caCert"])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var clientCert *tls.Certificate
+	if m.Properties["clientCert"] != "" || m.Properties["clientKey"] != "" {
+		clientCert, err = loadClientCert(m.Properties["clientCert"], m.Properties["clientKey"])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	client := &httpClient{}
+
+	// This is called only when the server requests a client certificate
+	transport.TLSClientConfig.GetClientCertificate = func(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		client.certRequested.Store(true)
+
+		if clientCert == nil {
+			// An empty certificate means none is sent
+			return &tls.Certificate{}, nil
+		}
+
+		return clientCert, nil
+	}
+
+	if clientCert != nil {
+		client.clientCert = clientCert.Leaf
+	}
+
+	// Proxy can be http://, https:// or socks5://, when not set the standard env vars are used
+	proxyProp := m.Properties["proxy"]
+	if proxyProp != "" {
+		proxyURL, err := url.Parse(proxyProp)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: %w", err)
+		}
+
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+			transport.Proxy = http.ProxyURL(proxyURL)
+		default:
+			return nil, fmt.Errorf("proxy: unsupported scheme '%s'", proxyURL.Scheme)
+		}
+	}
+
+	switch m.Properties["httpVersion"] {
+	case "":
+	case "1.1":
+		// A non-nil empty map disables HTTP/2
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	case "2":
+		transport.ForceAttemptHTTP2 = true
+		transport.TLSClientConfig.NextProtos = []string{"h2"}
+	default:
+		return nil, fmt.Errorf("httpVersion: must be '1.1' or '2'")
+	}
+
+	sourceProp := m.Properties["source"]
+	if sourceProp != "" {
+		sourceIP, err := resolveSourceIP(sourceProp)
+		if err != nil {
+			return nil, fmt.Errorf("source: %w", err)
+		}
+
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			LocalAddr: &net.TCPAddr{IP: sourceIP},
+		}
+
+		transport.DialContext = dialer.DialContext
+	}
+
+	client.Client = &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(_ *http.Request, via []*http.Request) error {
+			// Returns the redirect response itself, rather than following it
+			if !followRedirects {
+				return http.ErrUseLastResponse
+			}
+
+			if len(via) > maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+
+			return nil
+		},
+	}
+
+	return client, nil
+}
+
+// Source can be an IP address, or the name of a network interface to use the first address of
+func resolveSourceIP(source string) (net.IP, error) {
+	if ip := net.ParseIP(source); ip != nil {
+		return ip, nil
+	}
+
+	iface, err := net.InterfaceByName(source)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			return ipNet.IP, nil
+		}
+	}
+
+	return nil, fmt.Errorf("interface %s has no IP addresses", source)
+}
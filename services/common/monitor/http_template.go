@@ -0,0 +1,154 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon Runner - Templating for HTTP requests, for dynamic values like nonces
+// ----------------------------------------------------------------------------
+
+package monitor
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Data available to templates, the body is rendered first so headers can sign it
+type httpTemplateData struct {
+	Body string
+}
+
+// Functions available in the URL, headers & body of HTTP monitors
+var httpTemplateFuncs = template.FuncMap{
+	// Current time, in RFC3339 format unless a Go time layout is given
+	"now": func(layout ...string) string {
+		if len(layout) > 0 {
+			return time.Now().UTC().Format(layout[0])
+		}
+
+		return time.Now().UTC().Format(time.RFC3339)
+	},
+	"unix":         func() int64 { return time.Now().Unix() },
+	"unixMilli":    func() int64 { return time.Now().UnixMilli() },
+	"uuid":         newUUID,
+	"randomString": templateRandomString,
+	"base64":       func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"hmac":         func(key, msg string) string { return hex.EncodeToString(hmacSHA256([]byte(key), msg)) },
+	"hmacBase64":   func(key, msg string) string { return base64.StdEncoding.EncodeToString(hmacSHA256([]byte(key), msg)) },
+	"sha256":       sha256Hex,
+	"env":          templateEnv,
+	"file":         templateFile,
+}
+
+// Longest string randomString can make, so a template can't use up the memory
+const maxRandomStringLength = 1024
+
+// Random alphanumeric string of the given length
+func templateRandomString(length int) (string, error) {
+	const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+	if length < 1 || length > maxRandomStringLength {
+		return "", fmt.Errorf("randomString length must be between 1 and %d", maxRandomStringLength)
+	}
+
+	out := make([]byte, length)
+	for i := range out {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
+		if err != nil {
+			return "", err
+		}
+
+		out[i] = chars[n.Int64()]
+	}
+
+	return string(out), nil
+}
+
+// Only env vars with this prefix can be read, so templates can't read others such as the DB password
+const templateEnvPrefix = "NANOMON_SECRET_"
+
+// Value of an env var, templates also run in the API so only prefixed env vars are allowed
+func templateEnv(name string) (string, error) {
+	if !strings.HasPrefix(name, templateEnvPrefix) {
+		return "", fmt.Errorf("env var %s can't be used, only env vars starting %s are allowed", name, templateEnvPrefix)
+	}
+
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("env var %s is not set", name)
+	}
+
+	return value, nil
+}
+
+// Read a secret from a file in the TEMPLATE_SECRETS_DIR, e.g. one mounted from a Kubernetes secret
+// Names are relative to the directory, and can't point outside it, even with a symlink
+func templateFile(name string) (string, error) {
+	dir := os.Getenv("TEMPLATE_SECRETS_DIR")
+	if dir == "" {
+		return "", fmt.Errorf("file can't be used, TEMPLATE_SECRETS_DIR is not set")
+	}
+
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("file %s must be a name within TEMPLATE_SECRETS_DIR", name)
+	}
+
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+
+	path, err := filepath.EvalSymlinks(filepath.Join(root, name))
+	if err != nil {
+		return "", err
+	}
+
+	if rel, err := filepath.Rel(root, path); err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("file %s must be a name within TEMPLATE_SECRETS_DIR", name)
+	}
+
+	data, err := os.ReadFile(path)
+
+	return strings.TrimSpace(string(data)), err
+}
+
+// Render a template string, strings without any actions are returned untouched
+func renderHTTPTemplate(name, text string, data httpTemplateData) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	tmpl, err := template.New(name).Funcs(httpTemplateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("%s template: %w", name, err)
+	}
+
+	var out strings.Builder
+
+	err = tmpl.Execute(&out, data)
+	if err != nil {
+		return "", fmt.Errorf("%s template: %w", name, err)
+	}
+
+	return out.String(), nil
+}
+
+// Random version 4 UUID, as described in RFC 9562
+func newUUID() (string, error) {
+	uuid := make([]byte, 16)
+
+	_, err := rand.Read(uuid)
+	if err != nil {
+		return "", err
+	}
+
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:]), nil
+}
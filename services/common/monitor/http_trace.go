@@ -0,0 +1,138 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon Runner - HTTP request tracing, for a breakdown of request timings
+// ----------------------------------------------------------------------------
+
+package monitor
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timings collected during a single HTTP request. Hooks can be called from
+// the transport's own goroutines, so everything is guarded by the mutex
+type httpTrace struct {
+	mu sync.Mutex
+
+	start        time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	firstByte    time.Time
+
+	dnsTime     time.Duration
+	connectTime time.Duration
+	tlsTime     time.Duration
+	remoteIP    string
+	connReused  bool
+}
+
+// Create a trace for a request made with the given client, it should be created
+// just before the request is sent, hooks are attached with httptrace.WithClientTrace
+func newHTTPTrace(client *httpClient) (*httpTrace, *httptrace.ClientTrace) {
+	t := &httpTrace{start: time.Now()}
+
+	return t, &httptrace.ClientTrace{
+		// Called for every request, including redirects, so the timings are for the last connection
+		GetConn: func(_ string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+
+			t.resetConn()
+		},
+		DNSStart: func(_ httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+
+			t.dnsStart = time.Now()
+		},
+		DNSDone: func(_ httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+
+			t.dnsTime = time.Since(t.dnsStart)
+		},
+		// With multiple addresses there can be several attempts, the first start is kept
+		ConnectStart: func(_, _ string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+		},
+		ConnectDone: func(_, _ string, err error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+
+			if err == nil {
+				t.connectTime = time.Since(t.connectStart)
+			}
+		},
+		TLSHandshakeStart: func() {
+			// A new handshake resets this, when a connection is reused the last result still applies
+			client.certRequested.Store(false)
+
+			t.mu.Lock()
+			defer t.mu.Unlock()
+
+			t.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, _ error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+
+			t.tlsTime = time.Since(t.tlsStart)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+
+			t.connReused = info.Reused
+
+			if addr, ok := info.Conn.RemoteAddr().(*net.TCPAddr); ok {
+				t.remoteIP = addr.IP.String()
+			}
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+
+			t.firstByte = time.Now()
+		},
+	}
+}
+
+// Clear everything about the previous connection, the mutex must be held
+func (t *httpTrace) resetConn() {
+	t.dnsStart = time.Time{}
+	t.connectStart = time.Time{}
+	t.tlsStart = time.Time{}
+	t.dnsTime = 0
+	t.connectTime = 0
+	t.tlsTime = 0
+	t.remoteIP = ""
+	t.connReused = false
+}
+
+// Add the timings to the outputs, all times are in milliseconds. When a connection
+// is reused the DNS, connect & TLS times are zero, as none of those steps happen
+func (t *httpTrace) addOutputs(outputs map[string]any, end time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	outputs["dnsTime"] = durationMs(t.dnsTime)
+	outputs["connectTime"] = durationMs(t.connectTime)
+	outputs["tlsTime"] = durationMs(t.tlsTime)
+	outputs["remoteIp"] = t.remoteIP
+	outputs["connReused"] = t.connReused
+
+	if !t.firstByte.IsZero() {
+		outputs["ttfb"] = durationMs(t.firstByte.Sub(t.start))
+		outputs["transferTime"] = durationMs(end.Sub(t.firstByte))
+	}
+}
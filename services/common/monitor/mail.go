@@ -0,0 +1,639 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon Runner - Mail monitor implementation, for SMTP, IMAP & POP3
+// ----------------------------------------------------------------------------
+
+package monitor
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"nanomon/services/common/result"
+	"net"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	mailProtoSMTP = "smtp"
+	mailProtoIMAP = "imap"
+	mailProtoPOP3 = "pop3"
+
+	mailTLSStartTLS = "starttls"
+	mailTLSImplicit = "implicit"
+	mailTLSNone     = "none"
+)
+
+// Negative response from a POP3 server, as opposed to a network or protocol error
+type pop3Error string
+
+func (e pop3Error) Error() string {
+	return string(e)
+}
+
+// Holds the state of a conversation with a mail server
+type mailSession struct {
+	conn      net.Conn
+	text      *textproto.Conn
+	tlsConfig *tls.Config
+	tlsMode   string
+	tlsActive bool
+	imapTag   int
+	lastPhase time.Time
+	outputs   map[string]any
+}
+
+func (m *Monitor) runMail() *result.Result {
+	r := result.NewResult(m.Name, m.Target, m.ID)
+
+	var err error
+
+	timeout := time.Duration(10) * time.Second
+	protocol := mailProtoSMTP
+	tlsMode := mailTLSStartTLS
+	validateTLS := true
+	helo := "localhost"
+	mailbox := "INBOX"
+
+	timeoutProp := m.Properties["timeout"]
+	if timeoutProp != "" {
+		timeout, err = time.ParseDuration(timeoutProp)
+		if err != nil {
+			return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
+		}
+	}
+
+	protocolProp := m.Properties["protocol"]
+	if protocolProp != "" {
+		protocol = strings.ToLower(protocolProp)
+	}
+
+	if protocol != mailProtoSMTP && protocol != mailProtoIMAP && protocol != mailProtoPOP3 {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(fmt.Errorf("invalid mail protocol: %s", protocol)))
+	}
+
+	tlsProp := m.Properties["tls"]
+	if tlsProp != "" {
+		tlsMode = strings.ToLower(tlsProp)
+	}
+
+	if tlsMode != mailTLSStartTLS && tlsMode != mailTLSImplicit && tlsMode != mailTLSNone {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(fmt.Errorf("invalid tls mode: %s", tlsMode)))
+	}
+
+	validateTLSProp := m.Properties["validateTLS"]
+	if validateTLSProp != "" {
+		validateTLS, err = strconv.ParseBool(validateTLSProp)
+		if err != nil {
+			return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
+		}
+	}
+
+	if m.Properties["helo"] != "" {
+		helo = m.Properties["helo"]
+	}
+
+	if m.Properties["mailbox"] != "" {
+		mailbox = m.Properties["mailbox"]
+	}
+
+	host, _, err := net.SplitHostPort(m.Target)
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: !validateTLS,
+	}
+
+	err = applyTLSProps(tlsConfig, m.Properties)
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
+	}
+
+	dialer := net.Dialer{Timeout: timeout}
+	start := time.Now()
+
+	var conn net.Conn
+	if tlsMode == mailTLSImplicit {
+		conn, err = tls.DialWithDialer(&dialer, "tcp", m.Target, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", m.Target)
+	}
+
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+	}
+
+	// Timeout covers the whole conversation, not just the connect
+	_ = conn.SetDeadline(start.Add(timeout))
+
+	s := &mailSession{
+		conn:      conn,
+		text:      textproto.NewConn(conn),
+		tlsConfig: tlsConfig,
+		tlsMode:   tlsMode,
+		tlsActive: tlsMode == mailTLSImplicit,
+		lastPhase: start,
+		outputs: map[string]any{
+			"protocol":     protocol,
+			"tlsAvailable": tlsMode == mailTLSImplicit,
+		},
+	}
+
+	defer func() {
+		s.conn.Close()
+	}()
+
+	s.phase("connect")
+
+	username := m.Properties["username"]
+	password := m.Properties["password"]
+
+	switch protocol {
+	case mailProtoSMTP:
+		err = s.checkSMTP(helo, username, password)
+	case mailProtoIMAP:
+		err = s.checkIMAP(username, password, mailbox)
+	case mailProtoPOP3:
+		err = s.checkPOP3(username, password)
+	}
+
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+	}
+
+	r.Value = durationMs(time.Since(start))
+
+	s.outputs["respTime"] = int(r.Value)
+	s.outputs["tlsActive"] = s.tlsActive
+	s.outputs["authenticated"] = username != ""
+
+	if tlsConn, ok := s.conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		s.outputs["tlsVersion"] = tls.VersionName(state.Version)
+
+		if len(state.PeerCertificates) > 0 {
+			s.outputs["certExpiryDays"] = int(time.Until(state.PeerCertificates[0].NotAfter).Hours() / 24)
+		}
+	}
+
+	r.Outputs = s.outputs
+
+	return r
+}
+
+// Record the time taken since the last phase as an output, e.g. connectTime
+func (s *mailSession) phase(name string) {
+	s.outputs[name+"Time"] = int(time.Since(s.lastPhase).Milliseconds())
+	s.lastPhase = time.Now()
+}
+
+// Upgrade the connection to TLS, after a successful STARTTLS or equivalent command
+func (s *mailSession) startTLS() error {
+	tlsConn := tls.Client(s.conn, s.tlsConfig)
+
+	err := tlsConn.Handshake()
+	if err != nil {
+		return err
+	}
+
+	s.conn = tlsConn
+	s.text = textproto.NewConn(tlsConn)
+	s.tlsActive = true
+
+	return nil
+}
+
+// Check we're not about to send credentials in the clear, unless explicitly allowed
+func (s *mailSession) canAuth() error {
+	if !s.tlsActive && s.tlsMode != mailTLSNone {
+		return fmt.Errorf("refusing to send credentials without TLS, set tls to 'none' to allow")
+	}
+
+	return nil
+}
+
+// ============================================================================
+// SMTP
+// ============================================================================
+
+func (s *mailSession) checkSMTP(helo, username, password string) error {
+	_, banner, err := s.text.ReadResponse(220)
+	if err != nil {
+		return err
+	}
+
+	s.outputs["banner"] = strings.Split(banner, "\n")[0]
+	s.phase("banner")
+
+	ext, err := s.smtpEhlo(helo)
+	if err != nil {
+		return err
+	}
+
+	s.phase("hello")
+
+	_, hasStartTLS := ext["STARTTLS"]
+	if hasStartTLS {
+		s.outputs["tlsAvailable"] = true
+	}
+
+	if hasStartTLS && s.tlsMode == mailTLSStartTLS {
+		_, err = s.smtpCmd(220, "STARTTLS")
+		if err != nil {
+			return err
+		}
+
+		err = s.startTLS()
+		if err != nil {
+			return err
+		}
+
+		// Extensions can change once TLS is active, e.g. AUTH is often only offered now
+		ext, err = s.smtpEhlo(helo)
+		if err != nil {
+			return err
+		}
+
+		s.phase("tls")
+	}
+
+	extensions := []string{}
+	for k, v := range ext {
+		extensions = append(extensions, strings.TrimSpace(k+" "+v))
+	}
+
+	slices.Sort(extensions)
+	s.outputs["extensions"] = strings.Join(extensions, ", ")
+
+	if username != "" {
+		err = s.canAuth()
+		if err != nil {
+			return err
+		}
+
+		mechs := strings.Fields(strings.ToUpper(ext["AUTH"]))
+
+		switch {
+		case slices.Contains(mechs, "PLAIN"):
+			creds := base64.StdEncoding.EncodeToString([]byte("\x00" + username + "\x00" + password))
+
+			_, err = s.smtpCmd(235, "AUTH PLAIN %s", creds)
+
+		case slices.Contains(mechs, "LOGIN"):
+			_, err = s.smtpCmd(334, "AUTH LOGIN")
+			if err == nil {
+				_, err = s.smtpCmd(334, "%s", base64.StdEncoding.EncodeToString([]byte(username)))
+			}
+
+			if err == nil {
+				_, err = s.smtpCmd(235, "%s", base64.StdEncoding.EncodeToString([]byte(password)))
+			}
+
+		default:
+			err = fmt.Errorf("server has no supported AUTH mechanism, offered: %s", ext["AUTH"])
+		}
+
+		if err != nil {
+			return fmt.Errorf("SMTP auth failed: %w", err)
+		}
+
+		s.phase("auth")
+	}
+
+	_, _ = s.smtpCmd(221, "QUIT")
+
+	return nil
+}
+
+// Send a command and read the response, the expected code follows textproto rules
+func (s *mailSession) smtpCmd(expect int, format string, args ...any) (string, error) {
+	id, err := s.text.Cmd(format, args...)
+	if err != nil {
+		return "", err
+	}
+
+	s.text.StartResponse(id)
+	defer s.text.EndResponse(id)
+
+	_, msg, err := s.text.ReadResponse(expect)
+
+	return msg, err
+}
+
+// Send EHLO and return the advertised extensions, keyed by name
+func (s *mailSession) smtpEhlo(helo string) (map[string]string, error) {
+	msg, err := s.smtpCmd(250, "EHLO %s", helo)
+	if err != nil {
+		return nil, err
+	}
+
+	ext := map[string]string{}
+
+	// First line is the server greeting, the rest are extensions
+	lines := strings.Split(msg, "\n")
+	for _, line := range lines[1:] {
+		k, v, _ := strings.Cut(line, " ")
+		ext[strings.ToUpper(k)] = v
+	}
+
+	return ext, nil
+}
+
+// ============================================================================
+// IMAP
+// ============================================================================
+
+func (s *mailSession) checkIMAP(username, password, mailbox string) error {
+	greeting, err := s.text.ReadLine()
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(greeting, "* OK") && !strings.HasPrefix(greeting, "* PREAUTH") {
+		return fmt.Errorf("unexpected IMAP greeting: %s", greeting)
+	}
+
+	s.outputs["banner"] = strings.TrimPrefix(strings.TrimPrefix(greeting, "* OK "), "* PREAUTH ")
+	s.phase("banner")
+
+	caps, err := s.imapCapabilities()
+	if err != nil {
+		return err
+	}
+
+	s.phase("hello")
+
+	if slices.Contains(caps, "STARTTLS") {
+		s.outputs["tlsAvailable"] = true
+	}
+
+	if slices.Contains(caps, "STARTTLS") && s.tlsMode == mailTLSStartTLS {
+		_, err = s.imapCmd("STARTTLS")
+		if err != nil {
+			return err
+		}
+
+		err = s.startTLS()
+		if err != nil {
+			return err
+		}
+
+		caps, err = s.imapCapabilities()
+		if err != nil {
+			return err
+		}
+
+		s.phase("tls")
+	}
+
+	s.outputs["extensions"] = strings.Join(caps, ", ")
+
+	if username != "" {
+		err = s.canAuth()
+		if err != nil {
+			return err
+		}
+
+		_, err = s.imapCmd(fmt.Sprintf("LOGIN %s %s", imapQuote(username), imapQuote(password)))
+		if err != nil {
+			return err
+		}
+
+		s.phase("auth")
+
+		lines, err := s.imapCmd(`LIST "" "*"`)
+		if err != nil {
+			return err
+		}
+
+		mailboxCount := 0
+
+		for _, line := range lines {
+			if strings.HasPrefix(line, "* LIST ") {
+				mailboxCount++
+			}
+		}
+
+		s.outputs["mailboxCount"] = mailboxCount
+
+		lines, err = s.imapCmd("EXAMINE " + imapQuote(mailbox))
+		if err != nil {
+			return err
+		}
+
+		for _, line := range lines {
+			fields := strings.Fields(line)
+			if len(fields) == 3 && fields[0] == "*" && strings.EqualFold(fields[2], "EXISTS") {
+				s.outputs["messageCount"], _ = strconv.Atoi(fields[1])
+			}
+		}
+
+		s.phase("mailbox")
+	}
+
+	_, _ = s.imapCmd("LOGOUT")
+
+	return nil
+}
+
+// Send a tagged command, and return the untagged lines sent back before the tagged response
+func (s *mailSession) imapCmd(cmd string) ([]string, error) {
+	s.imapTag++
+	tag := fmt.Sprintf("a%d", s.imapTag)
+
+	err := s.text.PrintfLine("%s %s", tag, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := []string{}
+
+	for {
+		line, err := s.text.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+
+		if !strings.HasPrefix(line, tag+" ") {
+			lines = append(lines, line)
+			continue
+		}
+
+		status := strings.TrimPrefix(line, tag+" ")
+		if !strings.HasPrefix(strings.ToUpper(status), "OK") {
+			verb, _, _ := strings.Cut(cmd, " ")
+			return nil, fmt.Errorf("IMAP %s failed: %s", verb, status)
+		}
+
+		return lines, nil
+	}
+}
+
+func (s *mailSession) imapCapabilities() ([]string, error) {
+	lines, err := s.imapCmd("CAPABILITY")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range lines {
+		if strings.HasPrefix(line, "* CAPABILITY ") {
+			return strings.Fields(strings.TrimPrefix(line, "* CAPABILITY ")), nil
+		}
+	}
+
+	return []string{}, nil
+}
+
+// Quote a string for use in an IMAP command
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// ============================================================================
+// POP3
+// ============================================================================
+
+func (s *mailSession) checkPOP3(username, password string) error {
+	greeting, err := s.text.ReadLine()
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(greeting, "+OK") {
+		return fmt.Errorf("unexpected POP3 greeting: %s", greeting)
+	}
+
+	s.outputs["banner"] = strings.TrimSpace(strings.TrimPrefix(greeting, "+OK"))
+	s.phase("banner")
+
+	// CAPA is optional in POP3, so older servers might not support it
+	caps, err := s.pop3Capabilities()
+	if err != nil {
+		return err
+	}
+
+	s.phase("hello")
+
+	if slices.Contains(caps, "STLS") {
+		s.outputs["tlsAvailable"] = true
+	}
+
+	if slices.Contains(caps, "STLS") && s.tlsMode == mailTLSStartTLS {
+		_, err = s.pop3Cmd(false, "STLS")
+		if err != nil {
+			return err
+		}
+
+		err = s.startTLS()
+		if err != nil {
+			return err
+		}
+
+		caps, err = s.pop3Capabilities()
+		if err != nil {
+			return err
+		}
+
+		s.phase("tls")
+	}
+
+	s.outputs["extensions"] = strings.Join(caps, ", ")
+
+	if username != "" {
+		err = s.canAuth()
+		if err != nil {
+			return err
+		}
+
+		_, err = s.pop3Cmd(false, "USER %s", username)
+		if err == nil {
+			_, err = s.pop3Cmd(false, "PASS %s", password)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		s.phase("auth")
+
+		lines, err := s.pop3Cmd(false, "STAT")
+		if err != nil {
+			return err
+		}
+
+		// Response is in the form '+OK count size'
+		fields := strings.Fields(lines[0])
+		if len(fields) >= 3 {
+			s.outputs["messageCount"], _ = strconv.Atoi(fields[1])
+			s.outputs["mailboxSize"], _ = strconv.Atoi(fields[2])
+		}
+
+		s.phase("mailbox")
+	}
+
+	_, _ = s.pop3Cmd(false, "QUIT")
+
+	return nil
+}
+
+// Send a command, and return the response lines, the first being the status line
+func (s *mailSession) pop3Cmd(multiLine bool, format string, args ...any) ([]string, error) {
+	err := s.text.PrintfLine(format, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	line, err := s.text.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(line, "+OK") {
+		verb, _, _ := strings.Cut(format, " ")
+		return nil, pop3Error(fmt.Sprintf("POP3 %s failed: %s", verb, line))
+	}
+
+	lines := []string{line}
+
+	if multiLine {
+		dotLines, err := s.text.ReadDotLines()
+		if err != nil {
+			return nil, err
+		}
+
+		lines = append(lines, dotLines...)
+	}
+
+	return lines, nil
+}
+
+func (s *mailSession) pop3Capabilities() ([]string, error) {
+	lines, err := s.pop3Cmd(true, "CAPA")
+	if err != nil {
+		// Error from the server is fine, but not a broken connection
+		var negErr pop3Error
+		if errors.As(err, &negErr) {
+			return []string{}, nil
+		}
+
+		return nil, err
+	}
+
+	caps := []string{}
+
+	for _, line := range lines[1:] {
+		if name, _, _ := strings.Cut(line, " "); name != "" {
+			caps = append(caps, strings.ToUpper(name))
+		}
+	}
+
+	return caps, nil
+}
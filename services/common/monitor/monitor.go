@@ -11,9 +11,10 @@ import (
 	"nanomon/services/common/database"
 	"nanomon/services/common/result"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/Knetic/govaluate"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -21,8 +22,23 @@ const TypeHTTP = "http"
 const TypePing = "ping"
 const TypeTCP = "tcp"
 const TypeDNS = "dns"
+const TypeDomain = "domain"
+const TypeMail = "mail"
+const TypeAMQP = "amqp"
+const TypeNTP = "ntp"
+const TypeSystem = "system"
 
-var ValidTypes = []string{TypeHTTP, TypePing, TypeTCP, TypeDNS}
+var ValidTypes = []string{TypeHTTP, TypePing, TypeTCP, TypeDNS, TypeDomain, TypeMail, TypeAMQP, TypeNTP, TypeSystem}
+
+// Start of the result message when a rule is false
+const ruleViolated = "Rule violated"
+const critRuleViolated = "Critical rule violated"
+const warnRuleViolated = "Warning rule violated"
+
+// Start of the result message when a rule can't be evaluated
+const ruleExpressionError = "rule expression error"
+const ruleEvalError = "rule eval error"
+const ruleNotBool = "rule didn't return a bool"
 
 type Monitor struct {
 	ID         int
@@ -32,12 +48,19 @@ type Monitor struct {
 	Updated    time.Time         // Last time the monitor was updated
 	Enabled    bool              // Enable or disable the monitor
 	Rule       string            // Rules are run against the monitor result
+	WarnRule   string            // When false the result has warning status
+	CritRule   string            // When false the result has error status, same as Rule
+	RuleEngine string            // Language the rules are written in, govaluate or cel
+	Unit       string            // Unit of the result value, only used for display
+	Precision  int               // Decimal places to display the result value with
 	Target     string            // Target is the host to ping, or URL to check
 	Properties map[string]string // Set of properties varies per monitor type
 
 	// Used for alerts not stored in the database
-	ErrorCount   int  // Number of times the monitor has failed
-	InErrorState bool // If an alert is currently active
+	ErrorCount     int  // Number of times the monitor has failed
+	InErrorState   bool // If an alert is currently active
+	WarningCount   int  // Number of times in a row the monitor has had warning status
+	InWarningState bool // If a warning alert is currently active
 
 	// Callback event hooks
 	OnRunEnd func(m *Monitor, r *result.Result)
@@ -45,6 +68,28 @@ type Monitor struct {
 	// Prometheus things for this monitor
 	ticker *time.Ticker
 	gauge  *prometheus.GaugeVec
+	info   *prometheus.GaugeVec
+
+	// HTTP client is cached between runs, see getHTTPClient(). The mutex guards it
+	// as Stop() can be called while the monitor is running
+	httpClient   *httpClient
+	httpClientMu sync.Mutex
+
+	// OAuth2 token is cached between runs, see getOAuthToken()
+	oauthToken *oauthToken
+
+	// Used for content change detection, see detectChanges()
+	lastContentHash string
+	pendingSnapshot *Snapshot
+
+	// Recent results for rules over windows of runs, see history.functions()
+	history history
+
+	// Baselines for anomaly detection, see detectAnomaly()
+	anomaly *anomalyState
+
+	// Outputs of the last run with outputs, for rules using prev, see withPrevious()
+	previous map[string]any
 }
 
 // Start the monitor ticker, to run & execute the monitor on regular interval
@@ -76,6 +121,24 @@ func (m *Monitor) Start(delay int, db *database.DB) {
 	// Register the monitor as a Prometheus gauge
 	m.registerGauge()
 
+	// Pick up from the last stored result, so a restart isn't seen as a content change
+	if db != nil && m.Properties["detectChanges"] == "true" {
+		m.loadContentHash(db)
+	}
+
+	// Only rules using history functions need the recent results loaded
+	if db != nil && usesHistory(m.Rule, m.WarnRule, m.CritRule) {
+		m.loadHistory(db)
+	}
+
+	if db != nil && usesPrevious(m.Rule, m.WarnRule, m.CritRule) {
+		m.loadPrevious(db)
+	}
+
+	if db != nil && m.Properties["anomaly"] != "" {
+		m.loadBaseline(db)
+	}
+
 	// Run the monitor immediately on start
 	_, result := m.run()
 	if result != nil && db != nil {
@@ -85,6 +148,8 @@ func (m *Monitor) Start(delay int, db *database.DB) {
 		if err != nil {
 			log.Printf("Failed to store initial result for monitor '%s': %v", m.Name, err)
 		}
+
+		m.storeSnapshot(db)
 	}
 
 	m.ticker = time.NewTicker(intervalDuration)
@@ -99,6 +164,8 @@ func (m *Monitor) Start(delay int, db *database.DB) {
 			if err != nil {
 				log.Printf("Failed to store result for monitor '%s': %v", m.Name, err)
 			}
+
+			m.storeSnapshot(db)
 		}
 	}
 }
@@ -109,14 +176,62 @@ func (m *Monitor) run() (bool, *result.Result) {
 		return false, nil
 	}
 
+	res := m.execute()
+	if res == nil {
+		return false, nil
+	}
+
+	// Recorded with the final status, so failures() counts rule violations
+	m.history.add(newSample(res))
+
+	if res.Outputs != nil {
+		m.previous = res.Outputs
+	}
+
+	// Update the values in the Prometheus gauge
+	m.updateGauge(res)
+
+	// When the monitor run is complete, call the OnRunEnd callback if set
+	defer func() {
+		if m.OnRunEnd != nil {
+			m.OnRunEnd(m, res)
+		}
+	}()
+
+	// Warnings are tracked separately, the monitor is degraded but still working
+	switch res.Status {
+	case result.StatusOK:
+		m.ErrorCount = 0
+		m.InErrorState = false
+		m.WarningCount = 0
+		m.InWarningState = false
+
+	case result.StatusWarning:
+		m.ErrorCount = 0
+		m.InErrorState = false
+		m.WarningCount++
+
+	default:
+		m.ErrorCount++
+		m.WarningCount = 0
+		m.InWarningState = false
+
+		return false, res
+	}
+
+	return true, res
+}
+
+// Run the check for the monitor type and evaluate the rules, returns nil if the monitor can't run
+func (m *Monitor) execute() *result.Result {
 	if m.Target == "" {
 		log.Printf("Monitor '%s' has no target, will be skipped", m.Name)
-		return false, nil
+		return nil
 	}
 
 	var res *result.Result
 
-	log.Printf("Running monitor '%s' at '%s'", m.Name, m.Target)
+	log.Printf("Running monitor '%s' at '%s'", m.Name, RedactTarget(m.Target))
 
 	switch m.Type {
 	case TypeHTTP:
@@ -131,71 +246,178 @@ func (m *Monitor) run() (bool, *result.Result) {
 	case TypeDNS:
 		res = m.runDNS()
 
+	case TypeDomain:
+		res = m.runDomain()
+
+	case TypeMail:
+		res = m.runMail()
+
+	case TypeAMQP:
+		res = m.runAMQP()
+
+	case TypeNTP:
+		res = m.runNTP()
+
+	case TypeSystem:
+		res = m.runSystem()
+
 	default:
 		log.Printf("Unknown monitor type '%s', will be skipped", m.Type)
-		return false, nil
+		return nil
 	}
 
+	// Kept apart from the outputs by their declarations, so they are never stored
+	m.splitRuleOutputs(res)
+
 	if os.Getenv("DEBUG") == "true" {
 		log.Printf("DEBUG '%s' outputs: %+v", m.Name, res.Outputs)
 	}
 
-	// Logic block to evaluate the rule and set status & message accordingly
-	// At this stage a result will either be StatusOK or StatusFailed
-	if m.Rule != "" && res.Outputs != nil {
-		ruleExp, err := govaluate.NewEvaluableExpression(m.Rule)
-		if err != nil {
-			res.Message = fmt.Sprintf("rule expression error: %s", err.Error())
-			res.Status = result.StatusFailed
+	// Before the rules, so they can use the zScore output, errors are always with the settings
+	if err := m.detectAnomaly(res); err != nil {
+		res = result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
+	}
+
+	m.evaluateRules(res)
+
+	// Results are stored & returned by the API, so any password in the target is hidden
+	res.MonitorTarget = RedactTarget(m.Target)
+
+	return res
+}
+
+// Evaluate the rules and set status & message accordingly, before this a result will be StatusOK,
+// StatusError or StatusFailed, the rules can also give it StatusWarning
+func (m *Monitor) evaluateRules(res *result.Result) {
+	if res.Outputs == nil {
+		return
+	}
+
+	params := withPrevious(res.RuleParams(), m.previous)
+	current := newSample(res)
+
+	m.applyRule(res, m.Rule, params, current, result.StatusError, ruleViolated)
+	m.applyRule(res, m.CritRule, params, current, result.StatusError, critRuleViolated)
+	m.applyRule(res, m.WarnRule, params, current, result.StatusWarning, warnRuleViolated)
+}
+
+// Run the monitor once without storing the result or counting it towards alerts, it works
+// even when the monitor is disabled. The result keeps the rule only outputs
+func (m *Monitor) DryRun() *result.Result {
+	res := m.execute()
+	if res == nil {
+		err := fmt.Errorf("monitor can't be run, check the type and target")
+		return result.NewFailedResult(m.Name, RedactTarget(m.Target), m.ID, result.ConfigError(err))
+	}
+
+	return res
+}
+
+// Evaluate the rules against stored results, without running the check. Results should
+// be newest first, as returned from the database, but are evaluated oldest first so history
+// functions work. Only stored outputs are available to the rules, not the rule only ones
+func (m *Monitor) Replay(results []*result.Result) []*result.Result {
+	replayed := make([]*result.Result, len(results))
+
+	for i := len(results) - 1; i >= 0; i-- {
+		res := *results[i]
+
+		// Only status set by the old rules is reset, not from checks like expectStatus
+		if isRuleMessage(res.Message) {
+			res.Status = result.StatusOK
+			res.Message = ""
+			res.ErrorClass = ""
 		}
 
-		if ruleExp != nil {
-			ruleResult, err := ruleExp.Evaluate(res.Outputs)
-			if err != nil {
-				res.Message = fmt.Sprintf("rule eval error: %s", err.Error())
-				res.Status = result.StatusFailed
-			}
+		m.evaluateRules(&res)
 
-			ruleResultBool, isBool := ruleResult.(bool)
-			if !isBool && res.Status != result.StatusFailed {
-				res.Message = "rule didn't return a bool"
-				res.Status = result.StatusFailed
-			}
+		m.history.add(newSample(&res))
 
-			// Rule can put the result into error status
-			if !ruleResultBool && isBool && res.Status != result.StatusFailed {
-				res.Status = result.StatusError
-				res.Message = fmt.Sprintf("Rule violated: %s", m.Rule)
-			}
+		if res.Outputs != nil {
+			m.previous = res.Outputs
 		}
+
+		replayed[i] = &res
 	}
 
-	// Remove the body from the outputs after rules are checked
-	// TODO: Serious leakiness from HTTP monitor here
-	if res.Outputs["body"] != nil {
-		res.Outputs["body"] = "*** Removed ***"
+	return replayed
+}
+
+// Was the message set by a rule, either when it was false or couldn't be evaluated
+func isRuleMessage(message string) bool {
+	if message == ruleNotBool {
+		return true
 	}
 
-	// Update the values in the Prometheus gauge
-	m.updateGauge(res)
+	for _, prefix := range []string{ruleViolated, critRuleViolated, warnRuleViolated, ruleExpressionError, ruleEvalError} {
+		if strings.HasPrefix(message, prefix+":") {
+			return true
+		}
+	}
 
-	// When the monitor run is complete, call the OnRunEnd callback if set
-	defer func() {
-		if m.OnRunEnd != nil {
-			m.OnRunEnd(m, res)
+	return false
+}
+
+// Evaluate a rule against the result params, when the rule is false the result is given the
+// status, unless it's already in a worse state. Problems with the rule itself fail the result
+func (m *Monitor) applyRule(res *result.Result, rule string, params map[string]any, current sample, status int, violation string) {
+	if rule == "" {
+		return
+	}
+
+	evaluate, err := m.parseRule(rule, params, current)
+	if err != nil {
+		res.Message = fmt.Sprintf("%s: %s", ruleExpressionError, err.Error())
+		res.Status = result.StatusFailed
+		res.ErrorClass = result.ErrorConfig
+
+		return
+	}
+
+	ruleResult, err := evaluate(params)
+	if err != nil {
+		res.Message = fmt.Sprintf("%s: %s", ruleEvalError, err.Error())
+		res.Status = result.StatusFailed
+		res.ErrorClass = result.ErrorRule
+
+		return
+	}
+
+	ruleResultBool, isBool := ruleResult.(bool)
+	if !isBool {
+		res.Message = ruleNotBool
+		res.Status = result.StatusFailed
+		res.ErrorClass = result.ErrorConfig
+
+		return
+	}
+
+	if !ruleResultBool && result.Severity(status) > result.Severity(res.Status) {
+		res.Status = status
+		res.Message = fmt.Sprintf("%s: %s", violation, rule)
+		res.ErrorClass = result.ErrorRule
+	}
+}
+
+// Parse a rule with the rule engine of the monitor, returns a function to evaluate it
+func (m *Monitor) parseRule(rule string, params map[string]any, current sample) (func(map[string]any) (any, error), error) {
+	if m.RuleEngine == RuleEngineCEL {
+		prog, err := NewCELRule(m.Type, rule)
+		if err != nil {
+			return nil, err
 		}
-	}()
 
-	if res.Status > result.StatusOK {
-		m.ErrorCount++
+		return func(params map[string]any) (any, error) {
+			return evalCELRule(prog, m.Type, params)
+		}, nil
+	}
 
-		return false, res
-	} else {
-		m.ErrorCount = 0
-		m.InErrorState = false
+	ruleExp, err := newRuleExpression(rule, &m.history, current, params)
+	if err != nil {
+		return nil, err
 	}
 
-	return true, res
+	return ruleExp.Evaluate, nil
 }
 
 // Stop the monitor
@@ -208,4 +430,11 @@ func (m *Monitor) Stop() {
 	if m.ticker != nil {
 		m.ticker.Stop()
 	}
+
+	m.httpClientMu.Lock()
+	defer m.httpClientMu.Unlock()
+
+	if m.httpClient != nil {
+		m.httpClient.CloseIdleConnections()
+	}
 }
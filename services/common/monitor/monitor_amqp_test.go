@@ -0,0 +1,125 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon Runner - Tests for AMQP monitor
+// ----------------------------------------------------------------------------
+
+package monitor
+
+import (
+	"context"
+	"errors"
+	"nanomon/services/common/result"
+	"net"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Fake channel with a single queue, published messages are delivered back unless dropped
+type fakeAMQPChannel struct {
+	queue      amqp.Queue
+	deliveries chan amqp.Delivery
+	drop       bool
+}
+
+func (f *fakeAMQPChannel) QueueDeclare(string, bool, bool, bool, bool, amqp.Table) (amqp.Queue, error) {
+	return amqp.Queue{Name: "amq.gen-test"}, nil
+}
+
+func (f *fakeAMQPChannel) QueueDeclarePassive(name string, _, _, _, _ bool, _ amqp.Table) (amqp.Queue, error) {
+	if name != f.queue.Name {
+		return amqp.Queue{}, errors.New("NOT_FOUND - no queue")
+	}
+
+	return f.queue, nil
+}
+
+func (f *fakeAMQPChannel) Consume(string, string, bool, bool, bool, bool, amqp.Table) (<-chan amqp.Delivery, error) {
+	return f.deliveries, nil
+}
+
+func (f *fakeAMQPChannel) PublishWithContext(_ context.Context, _, _ string, _, _ bool, msg amqp.Publishing) error {
+	if !f.drop {
+		f.deliveries <- amqp.Delivery{MessageId: msg.MessageId}
+	}
+
+	return nil
+}
+
+func TestAMQPMonitor(t *testing.T) {
+	// Listener that accepts connections but never speaks AMQP
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	cases := []struct {
+		name   string
+		target string
+		props  map[string]string
+	}{
+		{"Bad URI", "amqp://goat:notaport", nil},
+		{"Bad scheme", "http://localhost:5672", nil},
+		{"Bad roundTrip", "localhost:5672", map[string]string{"roundTrip": "maybe"}},
+		{"Connection refused", "127.0.0.1:1", nil},
+		{"Not AMQP", listener.Addr().String(), map[string]string{"timeout": "200ms"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := Monitor{}
+			m.Name = tc.name
+			m.Enabled = true
+			m.Type = TypeAMQP
+			m.Target = tc.target
+			m.Properties = tc.props
+
+			ok, res := m.run()
+			if ok || res.Status != result.StatusFailed {
+				t.Errorf("AMQP monitor should return %d, got %d", result.StatusFailed, res.Status)
+			}
+		})
+	}
+}
+
+func TestAMQPChecks(t *testing.T) {
+	newFake := func(drop bool) *fakeAMQPChannel {
+		return &fakeAMQPChannel{
+			queue:      amqp.Queue{Name: "orders", Messages: 42, Consumers: 3},
+			deliveries: make(chan amqp.Delivery, 2),
+			drop:       drop,
+		}
+	}
+
+	outputs := map[string]any{}
+
+	err := amqpChecks(newFake(false), "orders", true, time.Second, outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if outputs["messageCount"] != 42 || outputs["consumerCount"] != 3 {
+		t.Errorf("Queue outputs should be 42 & 3, got %v & %v", outputs["messageCount"], outputs["consumerCount"])
+	}
+
+	if _, ok := outputs["roundTripTime"].(int); !ok {
+		t.Errorf("Round trip should set roundTripTime, got %v", outputs["roundTripTime"])
+	}
+
+	// Without a queue or round trip there's nothing to check
+	outputs = map[string]any{}
+
+	if err := amqpChecks(newFake(false), "", false, time.Second, outputs); err != nil || len(outputs) != 0 {
+		t.Errorf("No checks should add no outputs, got %v, %v", outputs, err)
+	}
+
+	if err := amqpChecks(newFake(false), "missing", false, time.Second, map[string]any{}); err == nil {
+		t.Errorf("Missing queue should return an error")
+	}
+
+	if err := amqpChecks(newFake(true), "", true, 50*time.Millisecond, map[string]any{}); err == nil {
+		t.Errorf("Round trip message never received should time out")
+	}
+}
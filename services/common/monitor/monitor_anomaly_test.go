@@ -0,0 +1,134 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon Runner - Tests for anomaly detection
+// ----------------------------------------------------------------------------
+
+package monitor
+
+import (
+	"math"
+	"nanomon/services/common/result"
+	"testing"
+	"time"
+)
+
+// Feed a steady value with a little noise into the monitor, then check one more value
+func feedAnomaly(t *testing.T, m *Monitor, date time.Time, value int) *result.Result {
+	t.Helper()
+
+	for i := range 30 {
+		res := result.NewResult(m.Name, m.Target, m.ID)
+		res.Date = date
+		res.Value = float64(100 + (i%3-1)*10)
+		res.Outputs = map[string]any{"respTime": res.Value}
+
+		if err := m.detectAnomaly(res); err != nil {
+			t.Fatal(err)
+		}
+
+		if res.Status != result.StatusOK {
+			t.Fatalf("Steady values should not be anomalies: %s", res.Message)
+		}
+	}
+
+	res := result.NewResult(m.Name, m.Target, m.ID)
+	res.Date = date
+	res.Value = float64(value)
+	res.Outputs = map[string]any{"respTime": value}
+
+	if err := m.detectAnomaly(res); err != nil {
+		t.Fatal(err)
+	}
+
+	return res
+}
+
+func TestAnomalyDetection(t *testing.T) {
+	now := time.Now()
+
+	cases := []struct {
+		name   string
+		props  map[string]string
+		value  int
+		status int
+	}{
+		{"Normal value", map[string]string{"anomaly": "ewma"}, 105, result.StatusOK},
+		{"High value", map[string]string{"anomaly": "ewma"}, 500, result.StatusWarning},
+		{"Low value", map[string]string{"anomaly": "ewma", "anomalyOutput": "respTime"}, 0, result.StatusWarning},
+		{"Critical", map[string]string{"anomaly": "ewma", "anomalyCrit": "5"}, 500, result.StatusError},
+		{"Only high", map[string]string{"anomaly": "ewma", "anomalyDirection": "high"}, 0, result.StatusOK},
+		{"Warning off", map[string]string{"anomaly": "ewma", "anomalyWarn": "0"}, 500, result.StatusOK},
+		{"Seasonal", map[string]string{"anomaly": "seasonal"}, 500, result.StatusWarning},
+		{"Not enough samples", map[string]string{"anomaly": "seasonal", "anomalyMinSamples": "50"}, 500, result.StatusOK},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &Monitor{Name: tc.name, Properties: tc.props}
+
+			res := feedAnomaly(t, m, now, tc.value)
+			if res.Status != tc.status {
+				t.Errorf("Result should have status %d, got %d (%s)", tc.status, res.Status, res.Message)
+			}
+		})
+	}
+
+	// Each hour of the week has its own baseline in seasonal mode
+	m := &Monitor{Name: "seasonal hours", Properties: map[string]string{"anomaly": "seasonal"}}
+	feedAnomaly(t, m, now, 100)
+
+	res := result.NewResult(m.Name, m.Target, m.ID)
+	res.Date = now.Add(time.Hour)
+	res.Outputs = map[string]any{"respTime": 500}
+
+	_ = m.detectAnomaly(res)
+
+	if _, ok := res.Outputs["zScore"]; ok {
+		t.Errorf("A different hour should have no baseline yet")
+	}
+
+	for _, props := range []map[string]string{
+		{"anomaly": "magic"},
+		{"anomaly": "ewma", "anomalyAlpha": "2"},
+		{"anomaly": "ewma", "anomalyWarn": "lots"},
+		{"anomaly": "ewma", "anomalyDirection": "sideways"},
+	} {
+		m := &Monitor{Name: "bad props", Properties: props}
+		res := result.NewResult(m.Name, m.Target, m.ID)
+		res.Outputs = map[string]any{"respTime": 5}
+
+		if err := m.detectAnomaly(res); err == nil {
+			t.Errorf("Properties %v should return an error", props)
+		}
+	}
+
+	// A missing output skips the check, it doesn't fail the result
+	m = &Monitor{Name: "missing output", Properties: map[string]string{"anomaly": "ewma", "anomalyOutput": "goats"}}
+	res = result.NewResult(m.Name, m.Target, m.ID)
+	res.Outputs = map[string]any{"respTime": 5}
+
+	if err := m.detectAnomaly(res); err != nil || res.Status != result.StatusOK || m.anomaly != nil {
+		t.Errorf("Missing output should skip the anomaly check, got %v", err)
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	b := baseline{}
+	for _, x := range []float64{2, 4, 4, 4, 5, 5, 7, 9} {
+		b.add(x)
+	}
+
+	if b.mean != 5 || math.Abs(b.variance-4) > 1e-9 {
+		t.Errorf("Baseline should have mean 5 & variance 4, got %f & %f", b.mean, b.variance)
+	}
+
+	// A steady value has no spread, but still gives a usable standard deviation
+	b = baseline{}
+	for range 10 {
+		b.addEWMA(200, 0.1)
+	}
+
+	if b.mean != 200 || b.stdDev() != 2 {
+		t.Errorf("Baseline should have mean 200 & floored std dev 2, got %f & %f", b.mean, b.stdDev())
+	}
+}
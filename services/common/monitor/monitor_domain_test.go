@@ -0,0 +1,128 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon Runner - Tests for domain monitor
+// ----------------------------------------------------------------------------
+
+package monitor
+
+import (
+	"fmt"
+	"nanomon/services/common/result"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Stand-in RDAP server, returns a domain expiring in the given number of days
+func newRDAPServer(expiryDays int) *httptest.Server {
+	expires := time.Now().Add(time.Duration(expiryDays)*24*time.Hour + time.Hour).UTC().Format(time.RFC3339)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/domain/example.com", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rdap+json")
+		fmt.Fprintf(w, `{
+			"objectClassName": "domain",
+			"ldhName": "EXAMPLE.COM",
+			"status": ["client delete prohibited", "client transfer prohibited"],
+			"events": [
+				{"eventAction": "registration", "eventDate": "1995-08-14T04:00:00Z"},
+				{"eventAction": "expiration", "eventDate": "%s"}
+			],
+			"entities": [{
+				"objectClassName": "entity",
+				"roles": ["registrar"],
+				"vcardArray": ["vcard", [["version", {}, "text", "4.0"], ["fn", {}, "text", "Goat Registrar Inc"]]]
+			}],
+			"nameservers": [
+				{"objectClassName": "nameserver", "ldhName": "A.IANA-SERVERS.NET"},
+				{"objectClassName": "nameserver", "ldhName": "B.IANA-SERVERS.NET"}
+			]
+		}`, expires)
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func TestDomainMonitor(t *testing.T) {
+	server := newRDAPServer(100)
+	defer server.Close()
+
+	cases := []struct {
+		name           string
+		target         string
+		rule           string
+		expectedStatus int
+	}{
+		{"Good", "example.com", "", result.StatusOK},
+		{"Subdomain", "https://www.example.com/some/path", "domain == 'example.com'", result.StatusOK},
+		{"Expiry rule", "example.com", "expiryDays > 90 && expiryDays < 110", result.StatusOK},
+		{"Expiry rule violated", "example.com", "expiryDays > 365", result.StatusError},
+		{"Registrar", "example.com", "registrar == 'Goat Registrar Inc'", result.StatusOK},
+		{"Status", "example.com", "status =~ 'client transfer prohibited'", result.StatusOK},
+		{"Nameservers", "example.com", "nameserverCount == 2 && nameservers =~ 'a.iana-servers.net'", result.StatusOK},
+		{"Not found", "notfound.com", "", result.StatusFailed},
+		{"Bad domain", "com", "", result.StatusFailed},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := Monitor{}
+			m.Name = tc.name
+			m.Enabled = true
+			m.Type = TypeDomain
+			m.Target = tc.target
+			m.Rule = tc.rule
+			m.Properties = map[string]string{
+				"server": server.URL,
+			}
+
+			_, res := m.run()
+			if res == nil {
+				t.Fatalf("Domain monitor should return a result")
+			}
+
+			if res.Status != tc.expectedStatus {
+				t.Errorf("Domain monitor should return %d, got %d: %s", tc.expectedStatus, res.Status, res.Message)
+			}
+		})
+	}
+}
+
+func TestDomainMonitorBootstrap(t *testing.T) {
+	server := newRDAPServer(10)
+	defer server.Close()
+
+	bootstrap := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"services": [[["net", "org"], ["http://localhost:1/"]], [["com"], ["%s/"]]]}`, server.URL)
+	}))
+	defer bootstrap.Close()
+
+	oldURL := rdapBootstrapURL
+	rdapBootstrapURL = bootstrap.URL
+	rdapBootstrap.services = nil
+
+	defer func() {
+		rdapBootstrapURL = oldURL
+		rdapBootstrap.services = nil
+	}()
+
+	m := Monitor{}
+	m.Name = "domain bootstrap"
+	m.Enabled = true
+	m.Type = TypeDomain
+	m.Target = "example.com"
+	m.Rule = "expiryDays < 30"
+
+	ok, res := m.run()
+	if !ok || res.Status != result.StatusOK {
+		t.Errorf("Domain monitor should use bootstrap server, got %d: %s", res.Status, res.Message)
+	}
+
+	m.Target = "example.xyz"
+
+	ok, res = m.run()
+	if ok || res.Status != result.StatusFailed {
+		t.Errorf("Domain monitor should fail for unknown TLD, got %d", res.Status)
+	}
+}
@@ -6,8 +6,28 @@
 package monitor
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
 	"nanomon/services/common/result"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type httpTestCase struct {
@@ -115,3 +135,538 @@ func TestHTTPMonitor(t *testing.T) {
 		})
 	}
 }
+
+func TestHTTPMonitorTLSIsolation(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	insecure := Monitor{Name: "insecure", Enabled: true, Type: TypeHTTP, Target: server.URL}
+	insecure.Properties = map[string]string{"validateTLS": "false"}
+
+	secure := Monitor{Name: "secure", Enabled: true, Type: TypeHTTP, Target: server.URL}
+
+	// The insecure monitor must not change how the secure one validates certs
+	if ok, res := insecure.run(); !ok {
+		t.Errorf("Monitor with validateTLS=false should succeed: %s", res.Message)
+	}
+
+	if ok, _ := secure.run(); ok {
+		t.Errorf("Monitor with validateTLS=true should fail against a self-signed cert")
+	}
+
+	if ok, _ := insecure.run(); !ok {
+		t.Errorf("Monitor with validateTLS=false should still succeed")
+	}
+}
+
+func TestHTTPMonitorClient(t *testing.T) {
+	var connCount atomic.Int32
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	}))
+	server.EnableHTTP2 = true
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connCount.Add(1)
+		}
+	}
+
+	server.StartTLS()
+	defer server.Close()
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	cases := []struct {
+		name   string
+		rule   string
+		props  map[string]string
+		runOK  bool
+		conns  int32
+		repeat int
+	}{
+		{"CA cert", "status == 200", map[string]string{"caCert": string(certPEM)}, true, 1, 1},
+		{"Bad CA cert", "", map[string]string{"caCert": "/goats/ca.pem"}, false, 0, 1},
+		{"Keep alive", "status == 200", map[string]string{"caCert": string(certPEM)}, true, 1, 3},
+		{"No keep alive", "status == 200", map[string]string{"caCert": string(certPEM), "keepAlive": "false"}, true, 3, 3},
+		{"HTTP 2", "body == 'HTTP/2.0'", map[string]string{"caCert": string(certPEM), "httpVersion": "2"}, true, 1, 1},
+		{"HTTP 1.1", "body == 'HTTP/1.1'", map[string]string{"caCert": string(certPEM), "httpVersion": "1.1"}, true, 1, 1},
+		{"Bad HTTP version", "", map[string]string{"httpVersion": "3"}, false, 0, 1},
+		{"Source IP", "status == 200", map[string]string{"caCert": string(certPEM), "source": "127.0.0.1"}, true, 1, 1},
+		{"Bad source", "", map[string]string{"source": "goat0"}, false, 0, 1},
+		{"Bad proxy", "", map[string]string{"proxy": "ftp://proxy:21"}, false, 0, 1},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			connCount.Store(0)
+
+			m := Monitor{Name: tc.name, Enabled: true, Type: TypeHTTP, Target: server.URL, Rule: tc.rule, Properties: tc.props}
+
+			for range tc.repeat {
+				ok, res := m.run()
+				if ok != tc.runOK {
+					t.Errorf("HTTP monitor should return %t: %s", tc.runOK, res.Message)
+				}
+			}
+
+			m.Stop()
+
+			if connCount.Load() != tc.conns {
+				t.Errorf("HTTP monitor should open %d connections, opened %d", tc.conns, connCount.Load())
+			}
+		})
+	}
+}
+
+func TestHTTPMonitorTimings(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("goats"))
+	}))
+	defer server.Close()
+
+	m := Monitor{Name: "timings", Enabled: true, Type: TypeHTTP, Target: server.URL}
+	m.Properties = map[string]string{"validateTLS": "false"}
+
+	defer m.Stop()
+
+	// First run opens a new connection, the second reuses it
+	rules := []string{
+		"!connReused && connectTime > 0 && tlsTime > 0 && ttfb > 0 && transferTime >= 0 && remoteIp == '127.0.0.1' && protocol == 'HTTP/1.1'",
+		"connReused && connectTime == 0 && tlsTime == 0 && ttfb > 0",
+	}
+
+	for _, rule := range rules {
+		m.Rule = rule
+
+		ok, res := m.run()
+		if !ok || res.Status != result.StatusOK {
+			t.Errorf("HTTP monitor timings should pass rule '%s': %s %v", rule, res.Message, res.Outputs)
+		}
+	}
+}
+
+func TestHTTPMonitorJSONPaths(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/text" {
+			_, _ = w.Write([]byte("not json"))
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"db":{"status":"up","latencyMs":12,"primary":true},"nodes":[{"name":"a"},{"name":"b"}],"error":null}`))
+	}))
+	defer server.Close()
+
+	paths := `{"dbStatus": "db.status", "dbLatency": "$.db.latencyMs", "primary": "db.primary", "nodeCount": "nodes.#", ` +
+		`"firstNode": "nodes.0.name", "db": "db", "err": "error"}`
+
+	cases := []struct {
+		name   string
+		target string
+		rule   string
+		paths  string
+		runOK  bool
+	}{
+		{"Types", server.URL, "dbStatus == 'up' && dbLatency < 50 && primary && nodeCount == 2 && firstNode == 'a'", paths, true},
+		{"Raw object", server.URL, "db =~ 'latencyMs.:12'", paths, true},
+		{"Missing path", server.URL, "goats == 1", `{"goats": "db.goats"}`, false},
+		{"Bad paths", server.URL, "", `["db.status"]`, false},
+		{"Not JSON", server.URL + "/text", "", paths, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := Monitor{Name: tc.name, Enabled: true, Type: TypeHTTP, Target: tc.target, Rule: tc.rule}
+			m.Properties = map[string]string{"jsonPaths": tc.paths}
+
+			ok, res := m.run()
+			if ok != tc.runOK {
+				t.Errorf("HTTP monitor should return %t: %d %s %v", tc.runOK, res.Status, res.Message, res.Outputs)
+			}
+
+			if tc.name == "Types" && res.Outputs["err"] != nil {
+				t.Errorf("JSON null should be a nil output, got %v", res.Outputs["err"])
+			}
+		})
+	}
+
+	if err := ValidateJSONPaths(paths); err != nil {
+		t.Errorf("Valid jsonPaths should pass validation: %v", err)
+	}
+
+	badPaths := []string{`["db.status"]`, `{"status": "db.status"}`, `{"zScore": "db.latencyMs"}`, `{"db-status": "db.status"}`, `{"1st": "nodes.0"}`}
+	for _, bad := range badPaths {
+		if err := ValidateJSONPaths(bad); err == nil {
+			t.Errorf("jsonPaths %s should fail validation", bad)
+		}
+	}
+}
+
+func TestHTTPMonitorRedirects(t *testing.T) {
+	// Redirects /hops/N to /hops/N-1 until zero is reached
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hops := 0
+		_, _ = fmt.Sscanf(r.URL.Path, "/hops/%d", &hops)
+
+		if hops > 0 {
+			http.Redirect(w, r, fmt.Sprintf("/hops/%d", hops-1), http.StatusFound)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("X-Version", "1.2.3")
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		name  string
+		path  string
+		rule  string
+		props map[string]string
+		runOK bool
+	}{
+		{"No redirect", "/hops/0", "redirectCount == 0 && finalUrl == '" + server.URL + "/hops/0'", nil, true},
+		{"Follow", "/hops/3", "redirectCount == 3 && finalUrl == '" + server.URL + "/hops/0' && status == 200", nil, true},
+		{"Timings of last hop", "/hops/2", "redirectCount == 2 && connReused && connectTime == 0 && dnsTime == 0", nil, true},
+		{"No query in finalUrl", "/hops/0?X-API-Key=secret", "finalUrl == '" + server.URL + "/hops/0'", nil, true},
+		{"Max redirects", "/hops/3", "redirectCount == 3", map[string]string{"maxRedirects": "3"}, true},
+		{"Too many redirects", "/hops/4", "", map[string]string{"maxRedirects": "3"}, false},
+		{"Don't follow", "/hops/3", "redirectCount == 0 && status == 302", map[string]string{"followRedirects": "false"}, true},
+		{"Bad maxRedirects", "/hops/0", "", map[string]string{"maxRedirects": "-1"}, false},
+		{
+			"Capture headers", "/hops/1", "headerCacheControl == 'max-age=60' && headerXVersion == '1.2.3' && headerXGoats == ''",
+			map[string]string{"captureHeaders": "cache-control, X-Version,X-Goats"}, true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := Monitor{Name: tc.name, Enabled: true, Type: TypeHTTP, Target: server.URL + tc.path, Rule: tc.rule, Properties: tc.props}
+
+			ok, res := m.run()
+			if ok != tc.runOK {
+				t.Errorf("HTTP monitor should return %t: %d %s %v", tc.runOK, res.Status, res.Message, res.Outputs)
+			}
+		})
+	}
+}
+
+func TestHTTPMonitorDetectChanges(t *testing.T) {
+	var page atomic.Value
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(page.Load().(string)))
+	}))
+	defer server.Close()
+
+	m := Monitor{Name: "changes", Enabled: true, Type: TypeHTTP, Target: server.URL}
+	m.Properties = map[string]string{"detectChanges": "true", "changeIgnore": `time: \d+`, "changeNormalise": "true"}
+
+	steps := []struct {
+		body     string
+		changed  bool
+		snapshot bool
+	}{
+		{"<p>Hello time: 1</p>", false, true},
+		{"<p>Hello time: 2</p>", false, false},
+		{"<p>Hello   time: 3</p>\n", false, false},
+		{"<p>Goodbye time: 4</p>", true, true},
+		{"<p>Goodbye time: 5</p>", false, false},
+	}
+
+	for i, step := range steps {
+		page.Store(step.body)
+
+		m.pendingSnapshot = nil
+
+		ok, res := m.run()
+		if !ok {
+			t.Fatalf("Step %d: HTTP monitor should succeed: %s", i, res.Message)
+		}
+
+		if res.Outputs["contentChanged"] != step.changed {
+			t.Errorf("Step %d: contentChanged should be %t", i, step.changed)
+		}
+
+		if (m.pendingSnapshot != nil) != step.snapshot {
+			t.Errorf("Step %d: snapshot should be queued %t", i, step.snapshot)
+		}
+
+		if step.snapshot && m.pendingSnapshot.Body != step.body {
+			t.Errorf("Step %d: snapshot should hold the raw body, got %s", i, m.pendingSnapshot.Body)
+		}
+	}
+
+	m.Properties["changeIgnore"] = "(goats"
+	if ok, _ := m.run(); ok {
+		t.Errorf("HTTP monitor should fail with invalid changeIgnore regex")
+	}
+}
+
+func TestHTTPMonitorBodyLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data": "` + strings.Repeat("a", 2000) + `"}`))
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		name  string
+		rule  string
+		props map[string]string
+		runOK bool
+	}{
+		{"Default", "!truncated && bodyLen == 2012 && body =~ 'aaa'", nil, true},
+		{"Truncated", "truncated && bodyLen == 1024", map[string]string{"maxBodySize": "1KB"}, true},
+		{"Regex on truncated", "regexMatch == 'aaaaaa'", map[string]string{"maxBodySize": "16", "bodyRegex": `(a{2,})`}, true},
+		{"JSON on truncated", "", map[string]string{"maxBodySize": "1kb", "jsonPaths": `{"data": "data"}`}, false},
+		{"Bad size", "", map[string]string{"maxBodySize": "lots"}, false},
+		{"Zero size", "", map[string]string{"maxBodySize": "0"}, false},
+		{"Over limit", "", map[string]string{"maxBodySize": "101MB"}, false},
+		{"Overflow", "", map[string]string{"maxBodySize": "8589934592GB"}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := Monitor{Name: tc.name, Enabled: true, Type: TypeHTTP, Target: server.URL, Rule: tc.rule, Properties: tc.props}
+
+			ok, res := m.run()
+			if ok != tc.runOK {
+				t.Errorf("HTTP monitor should return %t: %d %s %v", tc.runOK, res.Status, res.Message, res.Outputs)
+			}
+
+			// Body is for rules only, it must never be in the stored outputs
+			if _, found := res.Outputs["body"]; found {
+				t.Errorf("Body should not be in the outputs")
+			}
+		})
+	}
+}
+
+func TestHTTPMonitorExpectations(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		_, _ = w.Write([]byte("Welcome to the goat farm"))
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		name    string
+		path    string
+		rule    string
+		props   map[string]string
+		status  int
+		message string
+	}{
+		{"Status class", "/", "", map[string]string{"expectStatus": "2xx,301"}, result.StatusOK, ""},
+		{"Status not in class", "/down", "", map[string]string{"expectStatus": "2xx,301"}, result.StatusError, "status 503 not in 2xx,301"},
+		{"Status range", "/down", "", map[string]string{"expectStatus": "200, 500-503"}, result.StatusOK, ""},
+		{"Status bad", "/", "", map[string]string{"expectStatus": "2xx,goats"}, result.StatusFailed, "expectStatus: invalid status 'goats'"},
+		{"Status bad class", "/", "", map[string]string{"expectStatus": "9xx"}, result.StatusFailed, "expectStatus: invalid status class '9xx'"},
+		{"Keyword", "/", "", map[string]string{"expectKeyword": "goat farm"}, result.StatusOK, ""},
+		{"Keyword missing", "/", "", map[string]string{"expectKeyword": "sheep"}, result.StatusError, "keyword 'sheep' not found in body"},
+		{"No keyword", "/", "", map[string]string{"expectNoKeyword": "Error"}, result.StatusOK, ""},
+		{"No keyword found", "/", "", map[string]string{"expectNoKeyword": "goat"}, result.StatusError, "keyword 'goat' found in body"},
+		{"Rule on top", "/", "bodyLen > 1000", map[string]string{"expectStatus": "200"}, result.StatusError, "Rule violated: bodyLen > 1000"},
+		{"Expectation before rule", "/down", "bodyLen > 1000", map[string]string{"expectStatus": "200"}, result.StatusError, "status 503 not in 200"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := Monitor{Name: tc.name, Enabled: true, Type: TypeHTTP, Target: server.URL + tc.path, Rule: tc.rule, Properties: tc.props}
+
+			_, res := m.run()
+			if res.Status != tc.status || res.Message != tc.message {
+				t.Errorf("HTTP monitor should return %d '%s', got %d '%s'", tc.status, tc.message, res.Status, res.Message)
+			}
+		})
+	}
+}
+
+func TestHTTPMonitorTemplates(t *testing.T) {
+	// Checks everything sent was rendered, returning 400 with the reason if not
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		var payload struct {
+			ID   string `json:"id"`
+			Time int64  `json:"time"`
+		}
+
+		err := json.Unmarshal(body, &payload)
+		switch {
+		case err != nil:
+			http.Error(w, "body not JSON: "+string(body), http.StatusBadRequest)
+		case len(payload.ID) != 36 || time.Since(time.Unix(payload.Time, 0)) > time.Minute:
+			http.Error(w, "bad body values: "+string(body), http.StatusBadRequest)
+		case r.Header.Get("X-Signature") != hex.EncodeToString(hmacSHA256([]byte("k3y"), string(body))):
+			http.Error(w, "bad signature", http.StatusBadRequest)
+		case r.Header.Get("X-Secret") != "goats":
+			http.Error(w, "bad secret", http.StatusBadRequest)
+		case len(r.URL.Query().Get("nonce")) != 12:
+			http.Error(w, "bad nonce", http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("NANOMON_SECRET_TEST", "goats")
+
+	props := map[string]string{
+		"method":  "POST",
+		"body":    `{"id": "{{ uuid }}", "time": {{ unix }}}`,
+		"headers": `{"X-Signature": "{{ hmac \"k3y\" .Body }}", "X-Secret": "{{ env \"NANOMON_SECRET_TEST\" }}"}`,
+	}
+
+	m := Monitor{Name: "templates", Enabled: true, Type: TypeHTTP, Target: server.URL + "/?nonce={{ randomString 12 }}"}
+	m.Rule = "status == 200"
+	m.Properties = props
+
+	// Run twice to check values are fresh each time
+	for range 2 {
+		if ok, res := m.run(); !ok {
+			t.Errorf("HTTP monitor templates not rendered: %s", res.Message)
+		}
+	}
+
+	m.Properties = map[string]string{"headers": `{"X-Secret": "{{ env \"NANOMON_SECRET_GOATS\" }}"}`}
+	if ok, _ := m.run(); ok {
+		t.Errorf("HTTP monitor should fail when env var is missing")
+	}
+
+	// Only prefixed env vars & files in the secrets dir can be read
+	t.Setenv("NANOMON_TEST_PLAIN", "goats")
+
+	dir := t.TempDir()
+	t.Setenv("TEMPLATE_SECRETS_DIR", dir)
+
+	if err := os.WriteFile(filepath.Join(dir, "token"), []byte("s3cr3t\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink("/etc/hostname", filepath.Join(dir, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	if out, err := renderHTTPTemplate("test", `{{ file "token" }}`, httpTemplateData{}); err != nil || out != "s3cr3t" {
+		t.Errorf("File in the secrets dir should be read, got '%s' %v", out, err)
+	}
+
+	for _, tmpl := range []string{`{{ env "NANOMON_TEST_PLAIN" }}`, `{{ file "../token" }}`, `{{ file "/etc/hostname" }}`, `{{ file "escape" }}`} {
+		if _, err := renderHTTPTemplate("test", tmpl, httpTemplateData{}); err == nil {
+			t.Errorf("Template %s should not be allowed", tmpl)
+		}
+	}
+
+	for _, tmpl := range []string{`{{ randomString 0 }}`, `{{ randomString -1 }}`, `{{ randomString 1025 }}`} {
+		if _, err := renderHTTPTemplate("test", tmpl, httpTemplateData{}); err == nil {
+			t.Errorf("Template %s should fail, the length is out of range", tmpl)
+		}
+	}
+
+	m.Properties = map[string]string{"body": "{{ goats }}"}
+	if ok, _ := m.run(); ok {
+		t.Errorf("HTTP monitor should fail with bad template")
+	}
+}
+
+func TestHTTPMonitorProxy(t *testing.T) {
+	// Acts as a forward proxy, which sees the full URL of the request
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("proxied " + r.URL.String()))
+	}))
+	defer proxy.Close()
+
+	m := Monitor{Name: "proxy", Enabled: true, Type: TypeHTTP, Target: "http://goats.example.net/"}
+	m.Rule = "body == 'proxied http://goats.example.net/'"
+	m.Properties = map[string]string{"proxy": proxy.URL}
+
+	if ok, res := m.run(); !ok {
+		t.Errorf("HTTP monitor should go via proxy: %d %s", res.Status, res.Message)
+	}
+}
+
+func TestHTTPMonitorClientCert(t *testing.T) {
+	certPEM, keyPEM := newTestClientCert(t, "nanomon-test")
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+
+	mtlsServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	mtlsServer.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+
+	mtlsServer.StartTLS()
+	defer mtlsServer.Close()
+
+	plainServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plainServer.Close()
+
+	t.Setenv("NANOMON_TEST_CLIENT_KEY", string(keyPEM))
+
+	certProps := map[string]string{"validateTLS": "false", "clientCert": string(certPEM), "clientKey": string(keyPEM)}
+	envProps := map[string]string{"validateTLS": "false", "clientCert": string(certPEM), "clientKey": "env:NANOMON_TEST_CLIENT_KEY"}
+
+	cases := []struct {
+		name   string
+		target string
+		rule   string
+		props  map[string]string
+		runOK  bool
+	}{
+		{"No cert", mtlsServer.URL, "", map[string]string{"validateTLS": "false"}, false},
+		{"Cert", mtlsServer.URL, "clientCertPresented && clientCertSubject == 'CN=nanomon-test'", certProps, true},
+		{"Cert from env", mtlsServer.URL, "clientCertPresented && clientCertExpiryDays == 0", envProps, true},
+		{"Not requested", plainServer.URL, "!clientCertRequested && !clientCertPresented", certProps, true},
+		{"Missing key", mtlsServer.URL, "", map[string]string{"clientCert": string(certPEM)}, false},
+		{"Missing env", mtlsServer.URL, "", map[string]string{"clientCert": string(certPEM), "clientKey": "env:NANOMON_GOATS"}, false},
+		{"Bad cert", mtlsServer.URL, "", map[string]string{"clientCert": "-----BEGIN GOATS", "clientKey": string(keyPEM)}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := Monitor{Name: tc.name, Enabled: true, Type: TypeHTTP, Target: tc.target, Rule: tc.rule, Properties: tc.props}
+			defer m.Stop()
+
+			// Run twice, the second run reuses the connection so there is no new handshake
+			for range 2 {
+				ok, res := m.run()
+				if ok != tc.runOK || (ok && res.Status != result.StatusOK) {
+					t.Errorf("HTTP monitor should return %t: %d %s", tc.runOK, res.Status, res.Message)
+				}
+			}
+		})
+	}
+}
+
+// Create a self-signed client certificate valid for one hour, returned as PEM
+func newTestClientCert(t *testing.T, commonName string) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
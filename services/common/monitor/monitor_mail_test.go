@@ -0,0 +1,153 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon Runner - Tests for mail monitor
+// ----------------------------------------------------------------------------
+
+package monitor
+
+import (
+	"bufio"
+	"nanomon/services/common/result"
+	"net"
+	"strings"
+	"testing"
+)
+
+// Scripted stand-in mail server, replies are keyed on the command verb, any
+// '{tag}' in a reply is replaced with the IMAP tag sent by the client
+func newFakeMailServer(t *testing.T, greeting string, replies map[string]string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				_, _ = conn.Write([]byte(greeting + "\r\n"))
+				scanner := bufio.NewScanner(conn)
+
+				for scanner.Scan() {
+					fields := strings.Fields(scanner.Text())
+					if len(fields) == 0 {
+						continue
+					}
+
+					tag, verb := "", fields[0]
+					if len(fields) > 1 && strings.HasPrefix(fields[0], "a") {
+						tag, verb = fields[0], fields[1]
+					}
+
+					reply, ok := replies[strings.ToUpper(verb)]
+					if !ok {
+						reply = "500 unknown command"
+					}
+
+					_, _ = conn.Write([]byte(strings.ReplaceAll(reply, "{tag}", tag) + "\r\n"))
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestMailMonitorSMTP(t *testing.T) {
+	addr := newFakeMailServer(t, "220 mail.example.net ESMTP Goat", map[string]string{
+		"EHLO": "250-mail.example.net\r\n250-PIPELINING\r\n250-SIZE 1000\r\n250 AUTH PLAIN LOGIN",
+		"AUTH": "235 2.7.0 Authentication successful",
+		"QUIT": "221 Bye",
+	})
+
+	cases := []struct {
+		name           string
+		rule           string
+		props          map[string]string
+		expectedStatus int
+	}{
+		{"Good", "banner =~ 'ESMTP Goat' && !tlsAvailable", map[string]string{}, result.StatusOK},
+		{"Extensions", "extensions =~ 'PIPELINING' && extensions =~ 'SIZE 1000'", map[string]string{}, result.StatusOK},
+		{"Auth without TLS", "", map[string]string{"username": "ozzy", "password": "bat"}, result.StatusFailed},
+		{"Auth", "authenticated && authTime >= 0", map[string]string{"username": "ozzy", "password": "bat", "tls": "none"}, result.StatusOK},
+		{"Bad protocol", "", map[string]string{"protocol": "goat"}, result.StatusFailed},
+		{"Bad TLS mode", "", map[string]string{"tls": "maybe"}, result.StatusFailed},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := Monitor{}
+			m.Name = tc.name
+			m.Enabled = true
+			m.Type = TypeMail
+			m.Target = addr
+			m.Rule = tc.rule
+			m.Properties = tc.props
+
+			_, res := m.run()
+			if res.Status != tc.expectedStatus {
+				t.Errorf("Mail monitor should return %d, got %d: %s", tc.expectedStatus, res.Status, res.Message)
+			}
+		})
+	}
+}
+
+func TestMailMonitorIMAP(t *testing.T) {
+	addr := newFakeMailServer(t, "* OK Goat IMAP ready", map[string]string{
+		"CAPABILITY": "* CAPABILITY IMAP4rev1 AUTH=PLAIN\r\n{tag} OK done",
+		"LOGIN":      "{tag} OK logged in",
+		"LIST":       "* LIST () \"/\" INBOX\r\n* LIST () \"/\" Sent\r\n* LIST () \"/\" Junk\r\n{tag} OK done",
+		"EXAMINE":    "* 42 EXISTS\r\n* 0 RECENT\r\n{tag} OK [READ-ONLY] done",
+		"LOGOUT":     "* BYE\r\n{tag} OK done",
+	})
+
+	m := Monitor{}
+	m.Name = "imap"
+	m.Enabled = true
+	m.Type = TypeMail
+	m.Target = addr
+	m.Rule = "banner == 'Goat IMAP ready' && mailboxCount == 3 && messageCount == 42 && extensions =~ 'IMAP4rev1'"
+	m.Properties = map[string]string{"protocol": "imap", "tls": "none", "username": "ozzy", "password": "bat"}
+
+	ok, res := m.run()
+	if !ok {
+		t.Errorf("IMAP monitor should return OK, got %d: %s", res.Status, res.Message)
+	}
+}
+
+func TestMailMonitorPOP3(t *testing.T) {
+	addr := newFakeMailServer(t, "+OK Goat POP3 ready", map[string]string{
+		"CAPA": "+OK\r\nUSER\r\nUIDL\r\n.",
+		"USER": "+OK",
+		"PASS": "-ERR bad password",
+		"QUIT": "+OK",
+	})
+
+	m := Monitor{}
+	m.Name = "pop3"
+	m.Enabled = true
+	m.Type = TypeMail
+	m.Target = addr
+	m.Rule = "banner == 'Goat POP3 ready' && extensions == 'USER, UIDL'"
+	m.Properties = map[string]string{"protocol": "pop3"}
+
+	ok, res := m.run()
+	if !ok {
+		t.Errorf("POP3 monitor should return OK, got %d: %s", res.Status, res.Message)
+	}
+
+	m.Properties = map[string]string{"protocol": "pop3", "tls": "none", "username": "ozzy", "password": "bat"}
+
+	ok, res = m.run()
+	if ok || res.Status != result.StatusFailed {
+		t.Errorf("POP3 monitor should fail with bad password, got %d", res.Status)
+	}
+}
@@ -0,0 +1,105 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon Runner - Tests for NTP monitor
+// ----------------------------------------------------------------------------
+
+package monitor
+
+import (
+	"encoding/binary"
+	"nanomon/services/common/result"
+	"net"
+	"testing"
+	"time"
+)
+
+// Stand-in NTP server, with a clock skewed from ours by the given amount
+func newNTPServer(t *testing.T, skew time.Duration, stratum byte, refID string) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 48)
+
+		for {
+			_, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			resp := make([]byte, 48)
+			resp[0] = 4<<3 | 4
+			resp[1] = stratum
+			copy(resp[12:16], refID)
+			binary.BigEndian.PutUint32(resp[4:], 1<<15)
+			copy(resp[24:32], buf[40:48])
+			binary.BigEndian.PutUint64(resp[32:], toNTPTime(time.Now().Add(skew)))
+			binary.BigEndian.PutUint64(resp[40:], toNTPTime(time.Now().Add(skew)))
+
+			_, _ = conn.WriteTo(resp, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestNTPMonitor(t *testing.T) {
+	cases := []struct {
+		name           string
+		skew           time.Duration
+		stratum        byte
+		refID          string
+		rule           string
+		expectedStatus int
+	}{
+		{"In sync", 0, 1, "GPS", "offsetMs > -100 && offsetMs < 100 && delayMs >= 0", result.StatusOK},
+		{"Ahead", 5 * time.Second, 1, "GPS", "offsetMs > -100 && offsetMs < 100", result.StatusError},
+		{"Behind", -2 * time.Second, 1, "GPS", "offsetMs < -1900 && offsetMs > -2100", result.StatusOK},
+		{"Stratum & ref", 0, 1, "PPS", "stratum == 1 && referenceId == 'PPS' && rootDelayMs == 500", result.StatusOK},
+		{"Ref IP", 0, 2, "\x0a\x00\x00\x01", "referenceId == '10.0.0.1'", result.StatusOK},
+		{"Kiss of death", 0, 0, "RATE", "", result.StatusFailed},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := Monitor{}
+			m.Name = tc.name
+			m.Enabled = true
+			m.Type = TypeNTP
+			m.Target = newNTPServer(t, tc.skew, tc.stratum, tc.refID)
+			m.Rule = tc.rule
+
+			_, res := m.run()
+			if res.Status != tc.expectedStatus {
+				t.Errorf("NTP monitor should return %d, got %d: %s %v", tc.expectedStatus, res.Status, res.Message, res.Outputs)
+			}
+		})
+	}
+}
+
+func TestNTPMonitorNoServer(t *testing.T) {
+	m := Monitor{}
+	m.Name = "ntp no server"
+	m.Enabled = true
+	m.Type = TypeNTP
+	m.Target = "127.0.0.1:1"
+	m.Properties = map[string]string{"timeout": "200ms"}
+
+	ok, res := m.run()
+	if ok || res.Status != result.StatusFailed {
+		t.Errorf("NTP monitor should fail with no server, got %d", res.Status)
+	}
+}
+
+func TestNTPTimeConversion(t *testing.T) {
+	now := time.Now()
+
+	diff := fromNTPTime(toNTPTime(now)).Sub(now)
+	if diff > time.Microsecond || diff < -time.Microsecond {
+		t.Errorf("NTP time round trip should be lossless to within 1us, got %s", diff)
+	}
+}
@@ -0,0 +1,92 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon Runner - Tests for system monitor
+// ----------------------------------------------------------------------------
+
+//go:build linux
+
+package monitor
+
+import (
+	"nanomon/services/common/result"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Create a fake /proc with just the files the system monitor reads
+func newFakeProc(t *testing.T) string {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"stat":    "cpu  100 0 100 700 100 0 0 0 0 0\ncpu0 100 0 100 700 100 0 0 0 0 0\n",
+		"loadavg": "0.50 1.25 2.00 1/123 4567\n",
+		"meminfo": "MemTotal:       8192000 kB\nMemFree:         1024000 kB\nMemAvailable:    2048000 kB\n" +
+			"SwapTotal:       1024000 kB\nSwapFree:         768000 kB\n",
+		"sys/fs/file-nr": "2048\t0\t8192\n",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestSystemMonitor(t *testing.T) {
+	procPath := newFakeProc(t)
+
+	cases := []struct {
+		name           string
+		rule           string
+		props          map[string]string
+		expectedStatus int
+	}{
+		{
+			"Real proc", "cpuPercent >= 0 && memTotalMB > 0 && disk1Path == '/'",
+			map[string]string{"procPath": "/proc", "sampleTime": "50ms"}, result.StatusOK,
+		},
+		{"Load", "load1 == 0.5 && load5 == 1.25 && load15 == 2", nil, result.StatusOK},
+		{"Memory", "memTotalMB == 8000 && memAvailableMB == 2000 && memUsedPercent == 75", nil, result.StatusOK},
+		{"Swap", "swapTotalMB == 1000 && swapUsedMB == 250 && swapUsedPercent == 25", nil, result.StatusOK},
+		{"Files", "openFiles == 2048 && maxFiles == 8192 && openFilesPercent == 25", nil, result.StatusOK},
+		{
+			"Disks", "disk1Path == '/' && disk2Path == '" + procPath + "' && diskUsedPercentMax >= 0",
+			map[string]string{"mounts": "/, " + procPath}, result.StatusOK,
+		},
+		{"Rule violated", "load1 > 1", nil, result.StatusError},
+		{"Bad mount", "", map[string]string{"mounts": "/goats/are/great"}, result.StatusFailed},
+		{"Bad proc", "", map[string]string{"procPath": "/goats"}, result.StatusFailed},
+		{"Bad sample time", "", map[string]string{"sampleTime": "goat"}, result.StatusFailed},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := Monitor{}
+			m.Name = tc.name
+			m.Enabled = true
+			m.Type = TypeSystem
+			m.Target = "localhost"
+			m.Rule = tc.rule
+
+			// Default to the fake proc, and no wait between CPU samples
+			m.Properties = map[string]string{"procPath": procPath, "sampleTime": "0s"}
+			for k, v := range tc.props {
+				m.Properties[k] = v
+			}
+
+			_, res := m.run()
+			if res.Status != tc.expectedStatus {
+				t.Errorf("System monitor should return %d, got %d: %s %v", tc.expectedStatus, res.Status, res.Message, res.Outputs)
+			}
+		})
+	}
+}
@@ -6,11 +6,20 @@
 package monitor
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"nanomon/services/common/result"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func init() {
@@ -126,6 +135,183 @@ func TestMonitorRuleNotBool(t *testing.T) {
 	}
 }
 
+func TestMonitorRuleLevels(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		name     string
+		rule     string
+		warnRule string
+		critRule string
+		status   int
+		ok       bool
+	}{
+		{"All pass", "status == 202", "status == 202", "status < 300", result.StatusOK, true},
+		{"Warning", "", "status == 200", "status < 300", result.StatusWarning, true},
+		{"Critical", "", "status < 300", "status == 200", result.StatusError, false},
+		{"Both fail", "", "status == 200", "status == 200", result.StatusError, false},
+		{"Rule and warning", "status == 200", "status == 200", "", result.StatusError, false},
+		{"Bad warning rule", "", "goats > 3", "", result.StatusFailed, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := Monitor{}
+			m.Name = tc.name
+			m.Enabled = true
+			m.Type = TypeHTTP
+			m.Target = srv.URL
+			m.Rule = tc.rule
+			m.WarnRule = tc.warnRule
+			m.CritRule = tc.critRule
+
+			ok, res := m.run()
+			if ok != tc.ok || res.Status != tc.status {
+				t.Errorf("Monitor should return %v & status %d, got %v & %d (%s)", tc.ok, tc.status, ok, res.Status, res.Message)
+			}
+		})
+	}
+
+	// Warnings are counted separately, and don't count as errors
+	m := Monitor{Name: "warning counts", Enabled: true, Type: TypeHTTP, Target: srv.URL, WarnRule: "status == 200"}
+	m.ErrorCount = 2
+
+	m.run()
+	m.run()
+
+	if m.WarningCount != 2 || m.ErrorCount != 0 {
+		t.Errorf("Monitor should have 2 warnings & 0 errors, got %d & %d", m.WarningCount, m.ErrorCount)
+	}
+
+	m.WarnRule = ""
+	m.run()
+
+	if m.WarningCount != 0 {
+		t.Errorf("Warning count should be reset when OK, got %d", m.WarningCount)
+	}
+
+	m.WarnRule = "status == 200"
+	m.run()
+
+	m.Rule = "status == 200"
+	m.InWarningState = true
+	m.run()
+
+	if m.WarningCount != 0 || m.InWarningState || m.ErrorCount != 1 {
+		t.Errorf("Warnings should be reset by an error, got %d warnings & %d errors", m.WarningCount, m.ErrorCount)
+	}
+}
+
+func TestMonitorDryRun(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("all good"))
+	}))
+	defer srv.Close()
+
+	m := Monitor{Name: "dry run", Type: TypeHTTP, Target: srv.URL, Rule: "body == 'all good'"}
+
+	// Disabled monitors can still be tested, and rule outputs are kept
+	res := m.DryRun()
+	if res.Status != result.StatusOK || res.RuleOutputs["body"] != "all good" {
+		t.Errorf("Dry run should return OK with the body, got %d & %v", res.Status, res.RuleOutputs["body"])
+	}
+
+	if m.ErrorCount != 0 || m.history.count != 0 {
+		t.Errorf("Dry run should not change the monitor state")
+	}
+
+	m.Type = "goats"
+
+	res = m.DryRun()
+	if res.Status != result.StatusFailed {
+		t.Errorf("Dry run of a bad monitor should fail, got %d", res.Status)
+	}
+}
+
+func TestMonitorReplay(t *testing.T) {
+	// Newest first, as they come from the database
+	results := []*result.Result{
+		{Status: result.StatusError, Message: "Rule violated: respTime < 100", Outputs: map[string]any{"respTime": 300.0}},
+		{Status: result.StatusError, Message: "Status 500 not expected", Outputs: map[string]any{"respTime": 50.0}},
+		{Status: result.StatusFailed, Message: "connection refused"},
+		{Status: result.StatusOK, Outputs: map[string]any{"respTime": 100.0}},
+	}
+
+	m := Monitor{Name: "replay", Rule: "respTime < 400", WarnRule: "avg(respTime, 2) < 150"}
+	replayed := m.Replay(results)
+
+	expected := []int{result.StatusWarning, result.StatusError, result.StatusFailed, result.StatusOK}
+	for i, res := range replayed {
+		if res.Status != expected[i] {
+			t.Errorf("Result %d should have status %d, got %d (%s)", i, expected[i], res.Status, res.Message)
+		}
+	}
+
+	if results[0].Status != result.StatusError {
+		t.Errorf("Replay should not change the stored results")
+	}
+}
+
+func TestMonitorReplayRuleError(t *testing.T) {
+	// Stored when the rule used an output that wasn't there, the corrected rule should pass
+	results := []*result.Result{
+		{
+			Status:     result.StatusFailed,
+			Message:    "rule eval error: no such key: respTme",
+			ErrorClass: result.ErrorRule,
+			Outputs:    map[string]any{"respTime": 50.0},
+		},
+	}
+
+	m := Monitor{Name: "replay", Rule: "respTime < 100"}
+	replayed := m.Replay(results)
+
+	if replayed[0].Status != result.StatusOK || replayed[0].Message != "" || replayed[0].ErrorClass != "" {
+		t.Errorf("Rule error should be cleared by the corrected rule, got %d (%s)", replayed[0].Status, replayed[0].Message)
+	}
+}
+
+func TestMonitorPrevious(t *testing.T) {
+	// Newest first, the stored respTime is a float and the new one an int
+	results := []*result.Result{
+		{Status: result.StatusOK, Outputs: map[string]any{"ipAddress": "10.0.0.2", "respTime": 100}},
+		{Status: result.StatusFailed, Message: "connection refused"},
+		{Status: result.StatusOK, Outputs: map[string]any{"ipAddress": "10.0.0.1", "respTime": 100.0}},
+		{Status: result.StatusOK, Outputs: map[string]any{"ipAddress": "10.0.0.1", "respTime": 100.0}},
+	}
+
+	cases := []struct {
+		name   string
+		engine string
+		rule   string
+	}{
+		{"prev", "", "ipAddress == prev.ipAddress && respTime == prev.respTime"},
+		{"changed", "", "!changed('ipAddress') && !changed(respTime)"},
+		{"cel", RuleEngineCEL, "ipAddress == prev.ipAddress && respTime == prev.respTime"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := Monitor{Name: "previous", Type: TypeTCP, RuleEngine: tc.engine, Rule: tc.rule}
+			replayed := m.Replay(results)
+
+			expected := []int{result.StatusError, result.StatusFailed, result.StatusOK, result.StatusOK}
+			for i, res := range replayed {
+				if res.Status != expected[i] {
+					t.Errorf("Result %d should have status %d, got %d (%s)", i, expected[i], res.Status, res.Message)
+				}
+			}
+		})
+	}
+
+	if !usesPrevious("changed('ipAddress')") || !usesPrevious("prev.ipAddress != ipAddress") || usesPrevious("body == 'prev.ipAddress'") {
+		t.Errorf("usesPrevious() should find prev & changed() outside of strings")
+	}
+}
+
 func TestMonitorIntervalBad(t *testing.T) {
 	m := Monitor{}
 	m.Target = "http://dummy"
@@ -142,3 +328,308 @@ func TestMonitorIntervalBad(t *testing.T) {
 		t.Errorf("Ticker should be nil when monitor has no interval")
 	}
 }
+
+func TestRuleFunctions(t *testing.T) {
+	params := map[string]any{
+		"body":       "Welcome to the Goat Farm",
+		"status":     200,
+		"offsetMs":   -42.5,
+		"ip":         "10.1.2.3",
+		"expiryDate": time.Now().Add(72 * time.Hour).Format(time.RFC3339),
+	}
+
+	cases := []struct {
+		rule     string
+		expected any
+	}{
+		{"contains(body, 'Goat')", true},
+		{"contains(body, 'Sheep')", false},
+		{"startsWith(body, 'Welcome')", true},
+		{"matches(body, '^Welcome.*Farm$')", true},
+		{"len(body) == 24", true},
+		{"abs(offsetMs) < 100", true},
+		{"min(3, status, 7) == 3 && max(3, status, 7) == 200", true},
+		{"lower(body) == 'welcome to the goat farm'", true},
+		{"upper('goat') == 'GOAT'", true},
+		{"daysUntil(expiryDate) > 2.9 && daysUntil(expiryDate) < 3", true},
+		{"daysUntil('2000-01-01') < 0", true},
+		{"inCIDR(ip, '10.0.0.0/8')", true},
+		{"inCIDR(ip, '192.168.0.0/16')", false},
+		{"between(status, 200, 299)", true},
+		{"between(offsetMs, 0, 100)", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.rule, func(t *testing.T) {
+			exp, err := NewRuleExpression(tc.rule)
+			if err != nil {
+				t.Fatalf("Rule should parse: %v", err)
+			}
+
+			res, err := exp.Evaluate(params)
+			if err != nil {
+				t.Fatalf("Rule should evaluate: %v", err)
+			}
+
+			if res != tc.expected {
+				t.Errorf("Rule should return %v, got %v", tc.expected, res)
+			}
+		})
+	}
+
+	// Errors are returned rather than panics, for bad arguments
+	for _, rule := range []string{"abs(body) > 0", "between(1, 2) == true", "inCIDR(ip, 'goats')", "daysUntil('soon') > 0"} {
+		exp, err := NewRuleExpression(rule)
+		if err != nil {
+			t.Fatalf("Rule should parse: %v", err)
+		}
+
+		if _, err := exp.Evaluate(params); err == nil {
+			t.Errorf("Rule '%s' should return an error", rule)
+		}
+	}
+}
+
+func TestRuleHistory(t *testing.T) {
+	h := &history{}
+
+	// Older samples first, the failed run has no outputs
+	for i, respTime := range []int{100, 200, 300, 400} {
+		h.add(sample{status: result.StatusOK, values: map[string]float64{"respTime": float64(respTime), "value": float64(i)}})
+	}
+
+	h.add(sample{status: result.StatusFailed, values: map[string]float64{"value": 0}})
+	h.add(sample{status: result.StatusError, values: map[string]float64{"respTime": 900, "value": 5}})
+
+	current := sample{status: result.StatusOK, values: map[string]float64{"respTime": 500, "value": 8}}
+
+	cases := []struct {
+		rule     string
+		expected any
+	}{
+		{"avg(respTime, 1) == 500", true},
+		{"avg(respTime, 3) == 700", true},
+		{"avg(respTime, 100) == 400", true},
+		{"p50(respTime, 7) == 300", true},
+		{"p95(respTime, 20) == 900", true},
+		{"failures(3) == 2", true},
+		{"failures(1) == 0", true},
+		{"delta(value) == 3", true},
+		{"delta(respTime) == -400", true},
+		{"avg(respTime, 5) > 800 || respTime > 1000", false},
+		{"contains('avg(respTime, 5)', 'respTime')", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.rule, func(t *testing.T) {
+			exp, err := newRuleExpression(tc.rule, h, current, nil)
+			if err != nil {
+				t.Fatalf("Rule should parse: %v", err)
+			}
+
+			res, err := exp.Evaluate(map[string]any{"respTime": 500})
+			if err != nil {
+				t.Fatalf("Rule should evaluate: %v", err)
+			}
+
+			if res != tc.expected {
+				t.Errorf("Rule should return %v, got %v", tc.expected, res)
+			}
+		})
+	}
+
+	for _, rule := range []string{"avg(respTime, 0) > 0", "avg(respTime, 101) > 0", "avg(goats, 5) > 0", "failures() > 0"} {
+		exp, err := newRuleExpression(rule, h, current, nil)
+		if err != nil {
+			t.Fatalf("Rule should parse: %v", err)
+		}
+
+		if _, err := exp.Evaluate(nil); err == nil {
+			t.Errorf("Rule '%s' should return an error", rule)
+		}
+	}
+
+	// The ring buffer only keeps the most recent results
+	for range historySize * 2 {
+		h.add(current)
+	}
+
+	if len(h.last(historySize*2)) != historySize || h.last(historySize)[0].status != result.StatusOK {
+		t.Errorf("History should hold only the last %d results", historySize)
+	}
+}
+
+func TestCELRules(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"db": "Healthy"}`))
+	}))
+	defer srv.Close()
+
+	// Type checking catches these before the monitor is ever run
+	for _, rule := range []string{"stauts == 200", "respTime + 1", "status == 'ok'", "status =="} {
+		if err := ValidateRule(TypeHTTP, RuleEngineCEL, rule); err == nil {
+			t.Errorf("CEL rule '%s' should be rejected", rule)
+		}
+	}
+
+	if err := ValidateRule(TypeHTTP, RuleEngineGovaluate, "stauts == 200"); err != nil {
+		t.Errorf("Govaluate rule should only be syntax checked: %v", err)
+	}
+
+	cases := []struct {
+		rule   string
+		status int
+	}{
+		{"status == 200 && respTime < 5000", result.StatusOK},
+		{"body.lowerAscii().contains('healthy')", result.StatusOK},
+		{"outputs.dbStatus == 'Healthy' && dnsTime >= 0.0", result.StatusOK},
+		{"status == 404", result.StatusError},
+		{"outputs.goats == 'Healthy'", result.StatusFailed},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.rule, func(t *testing.T) {
+			m := Monitor{Name: "cel", Enabled: true, Type: TypeHTTP, Target: srv.URL, RuleEngine: RuleEngineCEL}
+			m.Rule = tc.rule
+			m.Properties = map[string]string{"jsonPaths": `{"dbStatus": "db"}`}
+
+			_, res := m.run()
+			if res.Status != tc.status {
+				t.Errorf("Monitor should return %d, got %d (%s)", tc.status, res.Status, res.Message)
+			}
+		})
+	}
+
+	// Stored outputs have been through JSON, so ints come back as floats
+	m := Monitor{Name: "cel replay", Type: TypeHTTP, RuleEngine: RuleEngineCEL, Rule: "status == 200"}
+
+	replayed := m.Replay([]*result.Result{{Outputs: map[string]any{"status": 500.0}}})
+	if replayed[0].Status != result.StatusError {
+		t.Errorf("Replayed CEL rule should return %d, got %d (%s)", result.StatusError, replayed[0].Status, replayed[0].Message)
+	}
+}
+
+func TestOutputDefs(t *testing.T) {
+	for _, monitorType := range ValidTypes {
+		for name, def := range TypeOutputDefs(monitorType) {
+			if def.Type == "" || def.Description == "" {
+				t.Errorf("Output '%s' of %s monitors should have a type & description", name, monitorType)
+			}
+
+			if def.RuleOnly && def.Gauge {
+				t.Errorf("Output '%s' of %s monitors can't be rule only and sent to Prometheus", name, monitorType)
+			}
+		}
+	}
+
+	m := Monitor{Name: "outputs test", Type: TypeHTTP, Unit: "ms"}
+	res := result.NewResult(m.Name, m.Target, m.ID)
+	res.Value = 12.5
+	res.Outputs = map[string]any{
+		"respTime":  12,
+		"body":      "hello",
+		"truncated": true,
+		"remoteIp":  "10.0.0.1",
+		"finalUrl":  "http://example.net",
+		"dbStatus":  "ok",
+		"dbCount":   3.0,
+	}
+
+	// Rule only outputs are moved out, so they are never stored
+	m.splitRuleOutputs(res)
+
+	if _, found := res.Outputs["body"]; found || res.RuleOutputs["body"] != "hello" {
+		t.Errorf("Body should only be in the rule outputs")
+	}
+
+	m.registerGauge()
+	defer m.unregisterGauge()
+
+	m.updateGauge(res)
+
+	// Text outputs aren't gauge values, and outputs declared as not gauged are skipped
+	if count := testutil.CollectAndCount(m.gauge); count != 6 {
+		t.Errorf("Gauge should have 6 series, got %d", count)
+	}
+
+	gauges := map[[2]string]float64{
+		{"_status", ""}:    0,
+		{"_warning", ""}:   0,
+		{"_value", "ms"}:   12.5,
+		{"respTime", "ms"}: 12,
+		{"truncated", ""}:  1,
+		{"dbCount", ""}:    3,
+	}
+
+	for labels, expected := range gauges {
+		if value := testutil.ToFloat64(m.gauge.WithLabelValues(labels[0], labels[1])); value != expected {
+			t.Errorf("Gauge %v should be %f, got %f", labels, expected, value)
+		}
+	}
+
+	// Warnings are a separate series, so they don't look worse than failures
+	res.Status = result.StatusWarning
+	m.updateGauge(res)
+
+	if testutil.ToFloat64(m.gauge.WithLabelValues("_status", "")) != 0 || testutil.ToFloat64(m.gauge.WithLabelValues("_warning", "")) != 1 {
+		t.Errorf("Warning should set _warning and leave _status as OK")
+	}
+
+	// Only declared text outputs which are gauged are sent as labels
+	if count := testutil.CollectAndCount(m.info); count != 1 || testutil.ToFloat64(m.info.WithLabelValues("remoteIp", "10.0.0.1")) != 1 {
+		t.Errorf("Info gauge should only have the remoteIp, got %d series", count)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	tlsSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	defer tlsSrv.Close()
+
+	// Grab a free port then close it, so nothing is listening
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closedAddr := listener.Addr().String()
+	listener.Close()
+
+	cases := []struct {
+		name  string
+		mon   *Monitor
+		class string
+	}{
+		{"Refused", &Monitor{Type: TypeTCP, Target: closedAddr}, result.ErrorConnRefused},
+		{"Config", &Monitor{Type: TypeTCP, Target: closedAddr, Properties: map[string]string{"timeout": "forever"}}, result.ErrorConfig},
+		{"DNS", &Monitor{Type: TypeTCP, Target: "nanomon.invalid:80"}, result.ErrorDNS},
+		{"TLS", &Monitor{Type: TypeHTTP, Target: tlsSrv.URL}, result.ErrorTLS},
+		{"Status", &Monitor{Type: TypeHTTP, Target: srv.URL, Properties: map[string]string{"expectStatus": "2xx"}}, result.ErrorHTTPStatus},
+		{"Rule", &Monitor{Type: TypeHTTP, Target: srv.URL, Rule: "status == 200"}, result.ErrorRule},
+		{"Bad rule", &Monitor{Type: TypeHTTP, Target: srv.URL, Rule: ",,3!"}, result.ErrorConfig},
+		{"OK", &Monitor{Type: TypeHTTP, Target: srv.URL, Rule: "status == 503"}, ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.mon.Name = "error class " + tc.name
+
+			res := tc.mon.DryRun()
+			if res.ErrorClass != tc.class {
+				t.Errorf("Result should have error class '%s', got '%s' (%s)", tc.class, res.ErrorClass, res.Message)
+			}
+		})
+	}
+
+	if class := result.ClassifyError(fmt.Errorf("wrapped: %w", context.DeadlineExceeded)); class != result.ErrorTimeout {
+		t.Errorf("Deadline exceeded should be a timeout, got '%s'", class)
+	}
+
+	if class := result.ClassifyError(errors.New("something else")); class != "" {
+		t.Errorf("Unknown errors should have no class, got '%s'", class)
+	}
+}
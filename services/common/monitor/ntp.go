@@ -0,0 +1,165 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon Runner - NTP monitor implementation, a minimal SNTP client (RFC 4330)
+// ----------------------------------------------------------------------------
+
+package monitor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"nanomon/services/common/result"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Seconds between the NTP epoch (1900) and the Unix epoch (1970)
+const ntpEpochOffset = 2208988800
+
+func (m *Monitor) runNTP() *result.Result {
+	r := result.NewResult(m.Name, m.Target, m.ID)
+
+	var err error
+
+	timeout := time.Duration(5) * time.Second
+	version := 4
+
+	timeoutProp := m.Properties["timeout"]
+	if timeoutProp != "" {
+		timeout, err = time.ParseDuration(timeoutProp)
+		if err != nil {
+			return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
+		}
+	}
+
+	versionProp := m.Properties["version"]
+	if versionProp != "" {
+		version, err = strconv.Atoi(versionProp)
+		if err != nil || version < 1 || version > 4 {
+			return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(fmt.Errorf("invalid NTP version: %s", versionProp)))
+		}
+	}
+
+	// Default to the standard NTP port if none given
+	addr := m.Target
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "123")
+	}
+
+	conn, err := net.DialTimeout("udp", addr, timeout)
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	// Request is a 48 byte packet with only the first byte & transmit time set
+	// First byte is leap indicator (0), version and mode (3 = client)
+	req := make([]byte, 48)
+	req[0] = byte(version<<3 | 3)
+
+	t1 := time.Now()
+	binary.BigEndian.PutUint64(req[40:], toNTPTime(t1))
+
+	_, err = conn.Write(req)
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+	}
+
+	resp := make([]byte, 48)
+
+	n, err := conn.Read(resp)
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+	}
+
+	t4 := time.Now()
+
+	if n < 48 {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, fmt.Errorf("NTP response too short, %d bytes", n))
+	}
+
+	leap := int(resp[0] >> 6)
+	mode := int(resp[0] & 0x07)
+	stratum := int(resp[1])
+
+	// Reject anything that isn't a reply to the request we just sent
+	if mode != 4 && mode != 5 {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, fmt.Errorf("unexpected NTP mode %d in response", mode))
+	}
+
+	if binary.BigEndian.Uint64(resp[24:]) != binary.BigEndian.Uint64(req[40:]) {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, fmt.Errorf("NTP response origin time does not match request"))
+	}
+
+	// Stratum 0 is a 'kiss-o'-death' packet, the reason is in the reference ID
+	if stratum == 0 {
+		kissCode := strings.TrimRight(string(resp[12:16]), "\x00")
+		return result.NewFailedResult(m.Name, m.Target, m.ID, fmt.Errorf("NTP server sent kiss code: %s", kissCode))
+	}
+
+	if leap == 3 {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, fmt.Errorf("NTP server clock is not synchronised"))
+	}
+
+	t2 := fromNTPTime(binary.BigEndian.Uint64(resp[32:]))
+	t3 := fromNTPTime(binary.BigEndian.Uint64(resp[40:]))
+
+	offset := (t2.Sub(t1) + t3.Sub(t4)) / 2
+	delay := t4.Sub(t1) - t3.Sub(t2)
+
+	// Reference ID is a four character code at stratum 1, otherwise an IPv4 address
+	refID := net.IP(resp[12:16]).String()
+	if stratum == 1 {
+		refID = strings.TrimRight(string(resp[12:16]), "\x00")
+	}
+
+	offsetMs := durationMs(offset)
+
+	outputs := map[string]any{
+		"respTime":         int(t4.Sub(t1).Milliseconds()),
+		"offsetMs":         offsetMs,
+		"delayMs":          durationMs(delay),
+		"stratum":          stratum,
+		"referenceId":      refID,
+		"leap":             leap,
+		"rootDelayMs":      ntpShortMs(binary.BigEndian.Uint32(resp[4:])),
+		"rootDispersionMs": ntpShortMs(binary.BigEndian.Uint32(resp[8:])),
+		"serverTime":       t3.UTC().Format(time.RFC3339Nano),
+	}
+
+	r.Value = offsetMs
+	r.Outputs = outputs
+
+	return r
+}
+
+// Convert a time to the 64-bit NTP timestamp format, 32 bits seconds & 32 bits fraction
+// Conversions here can't overflow for any time between 1900 and 2036
+func toNTPTime(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)  //nolint:gosec
+	frac := uint64(t.Nanosecond()) << 32 / 1e9 //nolint:gosec
+
+	return secs<<32 | frac
+}
+
+// Convert a 64-bit NTP timestamp to a time
+func fromNTPTime(ts uint64) time.Time {
+	secs := int64(ts>>32) - ntpEpochOffset        //nolint:gosec
+	nanos := int64((ts & 0xffffffff) * 1e9 >> 32) //nolint:gosec
+
+	return time.Unix(secs, nanos)
+}
+
+// Convert a 32-bit NTP short format value, 16 bits seconds & 16 bits fraction, to milliseconds
+func ntpShortMs(v uint32) float64 {
+	return float64(v) / 65536 * 1000
+}
+
+// Duration as fractional milliseconds, as clock offsets are often well under 1ms
+func durationMs(d time.Duration) float64 {
+	return float64(d.Nanoseconds()) / 1e6
+}
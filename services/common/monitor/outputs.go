@@ -0,0 +1,218 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon Runner - Declarations of the outputs each type of monitor returns
+// ----------------------------------------------------------------------------
+
+package monitor
+
+import (
+	"maps"
+	"nanomon/services/common/result"
+)
+
+// Types of output, any is for outputs which can be more than one type
+const OutputInt = "int"
+const OutputFloat = "float"
+const OutputString = "string"
+const OutputBool = "bool"
+const OutputAny = "any"
+
+// Units of outputs, these are also used as a label in Prometheus
+const UnitMs = "ms"
+const UnitDays = "days"
+const UnitBytes = "bytes"
+const UnitMB = "MB"
+const UnitPercent = "percent"
+
+// Declaration of a single output, outputs which aren't rule only are stored with the result
+type OutputDef struct {
+	Type        string `json:"type"`
+	Unit        string `json:"unit,omitempty"`
+	Description string `json:"description"`
+	Gauge       bool   `json:"gauge"`    // Sent to Prometheus, strings are sent as a label
+	RuleOnly    bool   `json:"ruleOnly"` // Only available to rules, never stored or sent to Prometheus
+}
+
+// An output which is stored and sent to Prometheus
+func gauged(outputType, unit, description string) OutputDef {
+	return OutputDef{Type: outputType, Unit: unit, Description: description, Gauge: true}
+}
+
+// An output which is stored but not sent to Prometheus
+func stored(outputType, description string) OutputDef {
+	return OutputDef{Type: outputType, Description: description}
+}
+
+// An output which is only available to rules, e.g. as it's too large to store
+func ruleOnly(outputType, description string) OutputDef {
+	return OutputDef{Type: outputType, Description: description, RuleOnly: true}
+}
+
+// Outputs which each monitor type can return. Outputs named at runtime, such as
+// jsonPaths, captureHeaders or the numbered DNS results, can't be declared here
+var OutputDefs = map[string]map[string]OutputDef{
+	TypeHTTP: {
+		"respTime":             gauged(OutputInt, UnitMs, "Time to complete the request & read the response"),
+		"status":               gauged(OutputInt, "", "HTTP status code of the response"),
+		"body":                 ruleOnly(OutputString, "Body of the response, up to the max body size"),
+		"bodyLen":              gauged(OutputInt, UnitBytes, "Length of the response body that was read"),
+		"truncated":            gauged(OutputBool, "", "The body was larger than the max body size"),
+		"certExpiryDays":       gauged(OutputInt, UnitDays, "Days until the server certificate expires"),
+		"regexMatch":           stored(OutputAny, "First group matched by bodyRegex, a number when it can be parsed as one"),
+		"contentHash":          stored(OutputString, "SHA-256 hash of the body, when detectChanges is set"),
+		"contentChanged":       gauged(OutputBool, "", "The body has changed since the last run, when detectChanges is set"),
+		"redirectCount":        gauged(OutputInt, "", "Number of redirects followed"),
+		"finalUrl":             stored(OutputString, "URL of the final response after redirects, without the query string"),
+		"clientCertRequested":  gauged(OutputBool, "", "The server asked for a client certificate"),
+		"clientCertPresented":  gauged(OutputBool, "", "A client certificate was sent to the server"),
+		"clientCertSubject":    stored(OutputString, "Subject of the client certificate"),
+		"clientCertExpiryDays": gauged(OutputInt, UnitDays, "Days until the client certificate expires"),
+		"dnsTime":              gauged(OutputFloat, UnitMs, "Time for the DNS lookup"),
+		"connectTime":          gauged(OutputFloat, UnitMs, "Time to open the TCP connection"),
+		"tlsTime":              gauged(OutputFloat, UnitMs, "Time for the TLS handshake"),
+		"ttfb":                 gauged(OutputFloat, UnitMs, "Time to the first byte of the response"),
+		"transferTime":         gauged(OutputFloat, UnitMs, "Time to read the response body"),
+		"connReused":           gauged(OutputBool, "", "An existing connection was used"),
+		"remoteIp":             gauged(OutputString, "", "IP address of the server"),
+		"protocol":             gauged(OutputString, "", "HTTP protocol used e.g. HTTP/2.0"),
+	},
+	TypePing: {
+		"minRtt":      gauged(OutputFloat, UnitMs, "Shortest round trip time"),
+		"avgRtt":      gauged(OutputFloat, UnitMs, "Average round trip time"),
+		"maxRtt":      gauged(OutputFloat, UnitMs, "Longest round trip time"),
+		"packetsRecv": gauged(OutputInt, "", "Number of replies received"),
+		"packetLoss":  gauged(OutputFloat, UnitPercent, "Packets without a reply"),
+		"ipAddress":   gauged(OutputString, "", "IP address which was pinged"),
+	},
+	TypeTCP: {
+		"respTime":  gauged(OutputInt, UnitMs, "Time to open the connection"),
+		"ipAddress": gauged(OutputString, "", "IP address connected to"),
+	},
+	TypeDNS: {
+		"respTime":    gauged(OutputInt, UnitMs, "Time for the lookup to complete"),
+		"resultCount": gauged(OutputInt, "", "Number of records returned"),
+	},
+	TypeDomain: {
+		"respTime":        gauged(OutputInt, UnitMs, "Time for the RDAP lookup to complete"),
+		"domain":          stored(OutputString, "Registered domain which was looked up"),
+		"expiryDays":      gauged(OutputInt, UnitDays, "Days until the domain registration expires"),
+		"expiryDate":      stored(OutputString, "Date the domain registration expires"),
+		"registrar":       gauged(OutputString, "", "Name of the registrar"),
+		"status":          stored(OutputString, "RDAP status values of the domain"),
+		"nameservers":     stored(OutputString, "Nameservers of the domain"),
+		"nameserverCount": gauged(OutputInt, "", "Number of nameservers"),
+	},
+	TypeMail: {
+		"respTime":       gauged(OutputInt, UnitMs, "Time to complete the whole conversation"),
+		"protocol":       gauged(OutputString, "", "Mail protocol used"),
+		"banner":         stored(OutputString, "Greeting sent by the server"),
+		"extensions":     stored(OutputString, "Extensions or capabilities of the server"),
+		"tlsAvailable":   gauged(OutputBool, "", "The server supports TLS"),
+		"tlsActive":      gauged(OutputBool, "", "The connection used TLS"),
+		"tlsVersion":     gauged(OutputString, "", "TLS version used"),
+		"certExpiryDays": gauged(OutputInt, UnitDays, "Days until the server certificate expires"),
+		"authenticated":  gauged(OutputBool, "", "Login was successful"),
+		"connectTime":    gauged(OutputInt, UnitMs, "Time to open the connection"),
+		"bannerTime":     gauged(OutputInt, UnitMs, "Time until the greeting was received"),
+		"helloTime":      gauged(OutputInt, UnitMs, "Time for the hello or capabilities command"),
+		"tlsTime":        gauged(OutputInt, UnitMs, "Time for the TLS handshake"),
+		"authTime":       gauged(OutputInt, UnitMs, "Time to log in"),
+		"mailboxTime":    gauged(OutputInt, UnitMs, "Time to check the mailbox"),
+		"messageCount":   gauged(OutputInt, "", "Number of messages in the mailbox"),
+		"mailboxCount":   gauged(OutputInt, "", "Number of IMAP mailboxes"),
+		"mailboxSize":    gauged(OutputInt, UnitBytes, "Size of the POP3 mailbox"),
+	},
+	TypeAMQP: {
+		"respTime":      gauged(OutputInt, UnitMs, "Time to complete all the checks"),
+		"connectTime":   gauged(OutputInt, UnitMs, "Time to open the connection"),
+		"messageCount":  gauged(OutputInt, "", "Number of messages in the queue"),
+		"consumerCount": gauged(OutputInt, "", "Number of consumers of the queue"),
+		"roundTripTime": gauged(OutputInt, UnitMs, "Time to publish & receive a test message"),
+		"serverProduct": gauged(OutputString, "", "Name of the broker software"),
+		"serverVersion": gauged(OutputString, "", "Version of the broker software"),
+	},
+	TypeNTP: {
+		"respTime":         gauged(OutputInt, UnitMs, "Time for the server to reply"),
+		"offsetMs":         gauged(OutputFloat, UnitMs, "Clock offset, positive means the server is ahead"),
+		"delayMs":          gauged(OutputFloat, UnitMs, "Round trip delay to the server"),
+		"stratum":          gauged(OutputInt, "", "Stratum of the server"),
+		"referenceId":      gauged(OutputString, "", "Reference clock of the server"),
+		"leap":             gauged(OutputInt, "", "Leap indicator, 3 means the clock is not synchronised"),
+		"rootDelayMs":      gauged(OutputFloat, UnitMs, "Delay to the reference clock"),
+		"rootDispersionMs": gauged(OutputFloat, UnitMs, "Dispersion to the reference clock"),
+		"serverTime":       stored(OutputString, "Time on the server"),
+	},
+	TypeSystem: {
+		"cpuPercent":         gauged(OutputFloat, UnitPercent, "CPU utilisation"),
+		"cpuCount":           gauged(OutputInt, "", "Number of CPUs"),
+		"load1":              gauged(OutputFloat, "", "Load average over 1 minute"),
+		"load5":              gauged(OutputFloat, "", "Load average over 5 minutes"),
+		"load15":             gauged(OutputFloat, "", "Load average over 15 minutes"),
+		"memTotalMB":         gauged(OutputInt, UnitMB, "Total memory"),
+		"memAvailableMB":     gauged(OutputInt, UnitMB, "Memory available"),
+		"memUsedPercent":     gauged(OutputFloat, UnitPercent, "Memory used"),
+		"swapTotalMB":        gauged(OutputInt, UnitMB, "Total swap"),
+		"swapUsedMB":         gauged(OutputInt, UnitMB, "Swap used"),
+		"swapUsedPercent":    gauged(OutputFloat, UnitPercent, "Swap used"),
+		"openFiles":          gauged(OutputInt, "", "Open file handles"),
+		"maxFiles":           gauged(OutputInt, "", "Maximum file handles"),
+		"openFilesPercent":   gauged(OutputFloat, UnitPercent, "File handles used"),
+		"diskUsedPercentMax": gauged(OutputFloat, UnitPercent, "Disk used, of the fullest disk"),
+	},
+}
+
+// Outputs any monitor type can return, depending on the properties set
+var CommonOutputDefs = map[string]OutputDef{
+	"baseline":       gauged(OutputFloat, "", "Mean of the anomaly baseline"),
+	"baselineStdDev": gauged(OutputFloat, "", "Standard deviation of the anomaly baseline"),
+	"zScore":         gauged(OutputFloat, "", "Standard deviations from the anomaly baseline"),
+}
+
+// All the outputs declared for a monitor type, including the common ones
+func TypeOutputDefs(monitorType string) map[string]OutputDef {
+	defs := maps.Clone(CommonOutputDefs)
+	maps.Copy(defs, OutputDefs[monitorType])
+
+	return defs
+}
+
+// Find the declaration of an output for the monitor type
+func outputDef(monitorType, name string) (OutputDef, bool) {
+	if def, ok := OutputDefs[monitorType][name]; ok {
+		return def, true
+	}
+
+	def, ok := CommonOutputDefs[name]
+
+	return def, ok
+}
+
+// Move the rule only outputs out of the outputs, so they are never stored or sent to Prometheus
+func (m *Monitor) splitRuleOutputs(res *result.Result) {
+	for name, value := range res.Outputs {
+		if def, ok := outputDef(m.Type, name); ok && def.RuleOnly {
+			if res.RuleOutputs == nil {
+				res.RuleOutputs = map[string]any{}
+			}
+
+			res.RuleOutputs[name] = value
+			delete(res.Outputs, name)
+		}
+	}
+}
+
+// Convert any of the numeric types outputs are returned as to a float
+func outputNumber(value any) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
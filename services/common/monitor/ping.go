@@ -26,7 +26,7 @@ func (m *Monitor) runPing() *result.Result {
 	if countProp != "" {
 		count, err = strconv.Atoi(countProp)
 		if err != nil {
-			return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+			return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
 		}
 	}
 
@@ -34,7 +34,7 @@ func (m *Monitor) runPing() *result.Result {
 	if intervalProp != "" {
 		interval, err = time.ParseDuration(intervalProp)
 		if err != nil {
-			return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+			return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
 		}
 	}
 
@@ -42,7 +42,7 @@ func (m *Monitor) runPing() *result.Result {
 	if timeoutProp != "" {
 		timeout, err = time.ParseDuration(timeoutProp)
 		if err != nil {
-			return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+			return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
 		}
 	}
 
@@ -65,15 +65,15 @@ func (m *Monitor) runPing() *result.Result {
 
 	stats := pinger.Statistics()
 	outputs := map[string]any{
-		"minRtt":      stats.MinRtt.Milliseconds(),
-		"maxRtt":      stats.MaxRtt.Milliseconds(),
-		"avgRtt":      stats.AvgRtt.Milliseconds(),
+		"minRtt":      durationMs(stats.MinRtt),
+		"maxRtt":      durationMs(stats.MaxRtt),
+		"avgRtt":      durationMs(stats.AvgRtt),
 		"packetLoss":  stats.PacketLoss,
 		"packetsRecv": stats.PacketsRecv,
-		"ipAddress":   stats.IPAddr,
+		"ipAddress":   stats.IPAddr.String(),
 	}
 
-	r.Value = int(stats.AvgRtt.Milliseconds())
+	r.Value = durationMs(stats.AvgRtt)
 	r.Outputs = outputs
 
 	return r
@@ -0,0 +1,121 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon Runner - Outputs of the previous run, for rules which detect changes
+// ----------------------------------------------------------------------------
+
+package monitor
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"nanomon/services/common/database"
+	"regexp"
+	"strings"
+
+	"github.com/Knetic/govaluate"
+)
+
+// Prefix for the outputs of the previous run in rules, e.g. prev.ipAddress
+const prevPrefix = "prev."
+
+// Finds prev.name in rules, quoted strings are matched so they can be skipped
+var prevRegex = regexp.MustCompile(`'[^']*'|"[^"]*"|\bprev\.([A-Za-z_][A-Za-z0-9_]*)`)
+
+// Any call to the changed function
+var changedFuncRegex = regexp.MustCompile(`\bchanged\s*\(`)
+
+// Add the previous outputs to the rule params as prev.name. Outputs the previous run didn't
+// have, or all of them on the first run, use the current value so they aren't seen as changes
+func withPrevious(params, previous map[string]any) map[string]any {
+	merged := make(map[string]any, len(params)*2)
+	maps.Copy(merged, params)
+
+	for name, value := range params {
+		if prevValue, ok := previous[name]; ok {
+			value = prevValue
+		}
+
+		merged[prevPrefix+name] = value
+	}
+
+	return merged
+}
+
+// Rule functions which compare with the previous run, the params should include prev.name
+func previousFunctions(params map[string]any) map[string]govaluate.ExpressionFunction {
+	return map[string]govaluate.ExpressionFunction{
+		"changed": func(args ...any) (any, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("changed() takes 1 argument")
+			}
+
+			output := fmt.Sprint(args[0])
+
+			now, ok := params[output]
+			if !ok {
+				return nil, fmt.Errorf("changed(): '%s' is not an output", output)
+			}
+
+			// Compared as strings, as stored outputs have been through JSON so all numbers are floats
+			return fmt.Sprint(now) != fmt.Sprint(params[prevPrefix+output]), nil
+		},
+	}
+}
+
+// The dot isn't valid in a govaluate name, so prev.name is wrapped in brackets e.g. [prev.name]
+func bracketPrevRefs(rule string) string {
+	return prevRegex.ReplaceAllStringFunc(rule, func(match string) string {
+		if strings.HasPrefix(match, "'") || strings.HasPrefix(match, `"`) {
+			return match
+		}
+
+		return "[" + match + "]"
+	})
+}
+
+// Check if any of the rules compare with the previous run
+func usesPrevious(rules ...string) bool {
+	for _, rule := range rules {
+		if changedFuncRegex.MatchString(rule) {
+			return true
+		}
+
+		for _, match := range prevRegex.FindAllStringSubmatch(rule, -1) {
+			if match[1] != "" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Load the outputs of the last stored result, so a restart isn't seen as a change
+func (m *Monitor) loadPrevious(db *database.DB) {
+	query := `
+		SELECT outputs
+		FROM results
+		WHERE monitor_id = $1 AND jsonb_typeof(outputs) = 'object'
+		ORDER BY date DESC
+		LIMIT 1
+	`
+
+	var outputs string
+
+	err := db.Handle.QueryRow(query, m.ID).Scan(&outputs)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to load previous result for monitor '%s': %v", m.Name, err)
+		}
+
+		return
+	}
+
+	if err := json.Unmarshal([]byte(outputs), &m.previous); err != nil {
+		log.Printf("Failed to load previous result for monitor '%s': %v", m.Name, err)
+	}
+}
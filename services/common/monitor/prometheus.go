@@ -21,21 +21,42 @@ func (m *Monitor) registerGauge() {
 		return
 	}
 
+	name := strings.ToLower(strings.ReplaceAll(m.Name, " ", "_"))
+	labels := prometheus.Labels{
+		"id":   fmt.Sprintf("%d", m.ID),
+		"type": m.Type,
+	}
+
 	m.gauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: strings.ToLower(strings.ReplaceAll(m.Name, " ", "_")),
-		Help: fmt.Sprintf("%s (%s)", m.Name, m.Type),
-		ConstLabels: prometheus.Labels{
-			"id":   fmt.Sprintf("%d", m.ID),
-			"type": m.Type,
-		},
-		Namespace: "nanomon",
-	}, []string{"result"})
+		Name:        name,
+		Help:        fmt.Sprintf("%s (%s)", m.Name, m.Type),
+		ConstLabels: labels,
+		Namespace:   "nanomon",
+	}, []string{"result", "unit"})
+
+	// Text outputs can't be a gauge value, so they are a label on a separate gauge which is always 1
+	m.info = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        name + "_info",
+		Help:        fmt.Sprintf("%s (%s) text outputs", m.Name, m.Type),
+		ConstLabels: labels,
+		Namespace:   "nanomon",
+	}, []string{"result", "value"})
 
 	err := prometheus.Register(m.gauge)
 	if err != nil {
 		log.Printf("Error registering gauge: %v\n", err)
 
 		m.gauge = nil
+		m.info = nil
+
+		return
+	}
+
+	err = prometheus.Register(m.info)
+	if err != nil {
+		log.Printf("Error registering info gauge: %v\n", err)
+
+		m.info = nil
 	}
 }
 
@@ -44,28 +65,62 @@ func (m *Monitor) unregisterGauge() {
 	if m.gauge != nil {
 		prometheus.Unregister(m.gauge)
 	}
+
+	if m.info != nil {
+		prometheus.Unregister(m.info)
+	}
 }
 
 // Update the gauge with the given monitor result
 func (m *Monitor) updateGauge(r *result.Result) {
-	if m.gauge != nil {
-		// Special labels for status and value
-		m.gauge.WithLabelValues("_status").Set(float64(r.Status))
-		m.gauge.WithLabelValues("_value").Set(float64(r.Value))
-
-		// Result will have a map of outputs, which could be multiple types
-		// Assert the type and set the gauge value if it is a float or int
-		for outKey := range r.Outputs {
-			outValI, ok := r.Outputs[outKey].(int)
-			if ok {
-				m.gauge.WithLabelValues(outKey).Set(float64(outValI))
-				continue
-			}
-
-			outValF, ok := r.Outputs[outKey].(float64)
-			if ok {
-				m.gauge.WithLabelValues(outKey).Set(outValF)
-			}
+	if m.gauge == nil {
+		return
+	}
+
+	// Warning has its own series, as its status of 3 would rank above failed in alerts on _status
+	status, warning := r.Status, 0.0
+	if status == result.StatusWarning {
+		status, warning = result.StatusOK, 1
+	}
+
+	// Special labels for status and value
+	m.gauge.WithLabelValues("_status", "").Set(float64(status))
+	m.gauge.WithLabelValues("_warning", "").Set(warning)
+	m.gauge.WithLabelValues("_value", m.Unit).Set(r.Value)
+
+	// Only the current text values should be labels
+	if m.info != nil {
+		m.info.Reset()
+	}
+
+	// The declaration of each output decides if it's sent, outputs named at runtime are
+	// sent when they are numbers or bools, but not text which could have any number of values
+	for outKey, outVal := range r.Outputs {
+		def, declared := outputDef(m.Type, outKey)
+		if declared && !def.Gauge {
+			continue
+		}
+
+		if num, ok := outputNumber(outVal); ok {
+			m.gauge.WithLabelValues(outKey, def.Unit).Set(num)
+			continue
+		}
+
+		if b, ok := outVal.(bool); ok {
+			m.gauge.WithLabelValues(outKey, def.Unit).Set(boolGauge(b))
+			continue
+		}
+
+		if declared && m.info != nil {
+			m.info.WithLabelValues(outKey, fmt.Sprint(outVal)).Set(1)
 		}
 	}
 }
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,212 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon Runner - Rule expressions and the library of functions they can use
+// ----------------------------------------------------------------------------
+
+package monitor
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Knetic/govaluate"
+)
+
+// Functions which can be used in rules, the API validates rules with the same set
+var RuleFunctions = map[string]govaluate.ExpressionFunction{
+	"contains": func(args ...any) (any, error) {
+		s, sub, err := twoStrings("contains", args)
+		return strings.Contains(s, sub), err
+	},
+	"startsWith": func(args ...any) (any, error) {
+		s, prefix, err := twoStrings("startsWith", args)
+		return strings.HasPrefix(s, prefix), err
+	},
+	"matches": func(args ...any) (any, error) {
+		s, pattern, err := twoStrings("matches", args)
+		if err != nil {
+			return nil, err
+		}
+
+		return regexp.MatchString(pattern, s)
+	},
+	"len": func(args ...any) (any, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("len() takes 1 argument")
+		}
+
+		return float64(len(fmt.Sprint(args[0]))), nil
+	},
+	"abs": func(args ...any) (any, error) {
+		nums, err := ruleNumbers("abs", args, 1, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		return math.Abs(nums[0]), nil
+	},
+	"min": func(args ...any) (any, error) {
+		nums, err := ruleNumbers("min", args, 1, -1)
+		if err != nil {
+			return nil, err
+		}
+
+		return minMax(nums, math.Min), nil
+	},
+	"max": func(args ...any) (any, error) {
+		nums, err := ruleNumbers("max", args, 1, -1)
+		if err != nil {
+			return nil, err
+		}
+
+		return minMax(nums, math.Max), nil
+	},
+	"lower": func(args ...any) (any, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("lower() takes 1 argument")
+		}
+
+		return strings.ToLower(fmt.Sprint(args[0])), nil
+	},
+	"upper": func(args ...any) (any, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("upper() takes 1 argument")
+		}
+
+		return strings.ToUpper(fmt.Sprint(args[0])), nil
+	},
+	"daysUntil": func(args ...any) (any, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("daysUntil() takes 1 argument")
+		}
+
+		t, err := ruleTime(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("daysUntil(): %w", err)
+		}
+
+		return time.Until(t).Hours() / 24, nil
+	},
+	"inCIDR": func(args ...any) (any, error) {
+		ipStr, cidr, err := twoStrings("inCIDR", args)
+		if err != nil {
+			return nil, err
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("inCIDR(): %w", err)
+		}
+
+		ip := net.ParseIP(ipStr)
+
+		return ip != nil && network.Contains(ip), nil
+	},
+	"between": func(args ...any) (any, error) {
+		nums, err := ruleNumbers("between", args, 3, 3)
+		if err != nil {
+			return nil, err
+		}
+
+		return nums[0] >= nums[1] && nums[0] <= nums[2], nil
+	},
+}
+
+// Parse a rule expression, with the function library available. History functions
+// only see the current run, as there's no history outside of a running monitor
+func NewRuleExpression(rule string) (*govaluate.EvaluableExpression, error) {
+	return newRuleExpression(rule, &history{}, sample{}, nil)
+}
+
+// Check a rule can be parsed by the rule engine, CEL rules are also type checked
+// against the outputs declared for the monitor type
+func ValidateRule(monitorType, engine, rule string) error {
+	if engine == RuleEngineCEL {
+		_, err := NewCELRule(monitorType, rule)
+		return err
+	}
+
+	_, err := NewRuleExpression(rule)
+
+	return err
+}
+
+// Parse a rule expression, with history functions working over the given history
+// and changed() comparing the params with the prev params
+func newRuleExpression(rule string, h *history, current sample, params map[string]any) (*govaluate.EvaluableExpression, error) {
+	functions := maps.Clone(RuleFunctions)
+	maps.Copy(functions, h.functions(current))
+	maps.Copy(functions, previousFunctions(params))
+
+	return govaluate.NewEvaluableExpressionWithFunctions(bracketPrevRefs(quoteHistoryArgs(rule)), functions)
+}
+
+func twoStrings(name string, args []any) (string, string, error) {
+	if len(args) != 2 {
+		return "", "", fmt.Errorf("%s() takes 2 arguments", name)
+	}
+
+	return fmt.Sprint(args[0]), fmt.Sprint(args[1]), nil
+}
+
+// Convert the arguments to numbers, checking the count is between min & max, -1 means no max
+func ruleNumbers(name string, args []any, minArgs, maxArgs int) ([]float64, error) {
+	if len(args) < minArgs || (maxArgs >= 0 && len(args) > maxArgs) {
+		return nil, fmt.Errorf("%s() called with wrong number of arguments", name)
+	}
+
+	nums := make([]float64, len(args))
+
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case float64:
+			nums[i] = v
+		case int:
+			nums[i] = float64(v)
+		case string:
+			num, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s() argument '%s' is not a number", name, v)
+			}
+
+			nums[i] = num
+		default:
+			return nil, fmt.Errorf("%s() argument %v is not a number", name, arg)
+		}
+	}
+
+	return nums, nil
+}
+
+func minMax(nums []float64, pick func(float64, float64) float64) float64 {
+	out := nums[0]
+	for _, n := range nums[1:] {
+		out = pick(out, n)
+	}
+
+	return out
+}
+
+// Times can be RFC3339 timestamps, plain dates or Unix times in seconds
+func ruleTime(arg any) (time.Time, error) {
+	switch v := arg.(type) {
+	case float64:
+		return time.Unix(int64(v), 0), nil
+	case int:
+		return time.Unix(int64(v), 0), nil
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, nil
+			}
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("'%v' is not a valid time", arg)
+}
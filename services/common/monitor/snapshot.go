@@ -0,0 +1,175 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon Runner - Content change detection & body snapshots for HTTP monitors
+// ----------------------------------------------------------------------------
+
+package monitor
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"nanomon/services/common/database"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A copy of the response body, kept each time the content changes
+type Snapshot struct {
+	Date      time.Time `json:"date"`
+	MonitorID int       `json:"monitor_id"`
+	Hash      string    `json:"hash"`
+	Body      string    `json:"body"`
+}
+
+// Hash the body and compare it with the previous run, setting the contentHash
+// and contentChanged outputs. A snapshot is queued whenever the content changes
+func (m *Monitor) detectChanges(body string, outputs map[string]any) error {
+	content := body
+
+	// Remove parts of the body that change every time, like timestamps or tokens
+	if m.Properties["changeIgnore"] != "" {
+		re, err := regexp.Compile(m.Properties["changeIgnore"])
+		if err != nil {
+			return fmt.Errorf("changeIgnore: %w", err)
+		}
+
+		content = re.ReplaceAllString(content, "")
+	}
+
+	if m.Properties["changeNormalise"] == "true" {
+		content = strings.Join(strings.Fields(content), " ")
+	}
+
+	hash := sha256.Sum256([]byte(content))
+	contentHash := hex.EncodeToString(hash[:])
+
+	// No previous hash means this is the first time the content has been seen
+	outputs["contentHash"] = contentHash
+	outputs["contentChanged"] = m.lastContentHash != "" && m.lastContentHash != contentHash
+
+	if m.lastContentHash != contentHash {
+		m.pendingSnapshot = &Snapshot{
+			Date:      time.Now(),
+			MonitorID: m.ID,
+			Hash:      contentHash,
+			Body:      body,
+		}
+	}
+
+	m.lastContentHash = contentHash
+
+	return nil
+}
+
+// Load the content hash from the most recent result, so changes are still
+// detected across restarts of the runner
+func (m *Monitor) loadContentHash(db *database.DB) {
+	query := `
+		SELECT outputs->>'contentHash'
+		FROM results
+		WHERE monitor_id = $1 AND outputs->>'contentHash' IS NOT NULL
+		ORDER BY date DESC
+		LIMIT 1
+	`
+
+	var hash string
+
+	err := db.Handle.QueryRow(query, m.ID).Scan(&hash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Failed to load content hash for monitor '%s': %v", m.Name, err)
+		return
+	}
+
+	m.lastContentHash = hash
+}
+
+// Store any snapshot queued by the last run, keeping only the most recent ones
+func (m *Monitor) storeSnapshot(db *database.DB) {
+	if m.pendingSnapshot == nil {
+		return
+	}
+
+	snapshot := m.pendingSnapshot
+	m.pendingSnapshot = nil
+
+	keep := 5
+
+	if m.Properties["changeSnapshots"] != "" {
+		var err error
+
+		keep, err = strconv.Atoi(m.Properties["changeSnapshots"])
+		if err != nil || keep < 0 {
+			log.Printf("Monitor '%s' has invalid changeSnapshots", m.Name)
+			return
+		}
+	}
+
+	if keep == 0 {
+		return
+	}
+
+	err := snapshot.Store(db, keep)
+	if err != nil {
+		log.Printf("Failed to store snapshot for monitor '%s': %v", m.Name, err)
+	}
+}
+
+// Store a snapshot in the database, removing older ones beyond the number to keep
+func (s *Snapshot) Store(db *database.DB, keep int) error {
+	query := `
+		INSERT INTO snapshots (date, monitor_id, hash, body)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	_, err := db.Handle.Exec(query, s.Date, s.MonitorID, s.Hash, s.Body)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		DELETE FROM snapshots
+		WHERE monitor_id = $1 AND id NOT IN (
+			SELECT id FROM snapshots WHERE monitor_id = $1 ORDER BY date DESC LIMIT $2
+		)
+	`
+
+	_, err = db.Handle.Exec(query, s.MonitorID, keep)
+
+	return err
+}
+
+// Get the stored snapshots for a monitor, newest first
+func GetSnapshots(db *database.DB, monitorID int) ([]*Snapshot, error) {
+	query := `
+		SELECT date, monitor_id, hash, body
+		FROM snapshots
+		WHERE monitor_id = $1
+		ORDER BY date DESC
+	`
+
+	rows, err := db.Handle.Query(query, monitorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snapshots := []*Snapshot{}
+
+	for rows.Next() {
+		var s Snapshot
+
+		if err := rows.Scan(&s.Date, &s.MonitorID, &s.Hash, &s.Body); err != nil {
+			return nil, err
+		}
+
+		snapshots = append(snapshots, &s)
+	}
+
+	return snapshots, rows.Err()
+}
@@ -0,0 +1,232 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon Runner - System monitor implementation, for the host the runner is on
+// ----------------------------------------------------------------------------
+
+//go:build linux
+
+package monitor
+
+import (
+	"bufio"
+	"fmt"
+	"nanomon/services/common/result"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func (m *Monitor) runSystem() *result.Result {
+	r := result.NewResult(m.Name, m.Target, m.ID)
+
+	var err error
+
+	procPath := "/proc"
+	sampleTime := time.Duration(1) * time.Second
+	mounts := []string{"/"}
+
+	if m.Properties["procPath"] != "" {
+		procPath = m.Properties["procPath"]
+	}
+
+	sampleTimeProp := m.Properties["sampleTime"]
+	if sampleTimeProp != "" {
+		sampleTime, err = time.ParseDuration(sampleTimeProp)
+		if err != nil {
+			return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
+		}
+	}
+
+	if m.Properties["mounts"] != "" {
+		mounts = []string{}
+
+		for _, mount := range strings.Split(m.Properties["mounts"], ",") {
+			if strings.TrimSpace(mount) != "" {
+				mounts = append(mounts, strings.TrimSpace(mount))
+			}
+		}
+	}
+
+	outputs := map[string]any{
+		"cpuCount": runtime.NumCPU(),
+	}
+
+	// CPU utilisation needs two samples of the counters, with a gap between them
+	total1, idle1, err := readCPUTimes(procPath)
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+	}
+
+	time.Sleep(sampleTime)
+
+	total2, idle2, err := readCPUTimes(procPath)
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+	}
+
+	cpuPercent := 0.0
+	if total2 > total1 {
+		cpuPercent = (1 - float64(idle2-idle1)/float64(total2-total1)) * 100
+	}
+
+	outputs["cpuPercent"] = cpuPercent
+
+	loadAvg, err := os.ReadFile(filepath.Join(procPath, "loadavg"))
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+	}
+
+	loadFields := strings.Fields(string(loadAvg))
+	if len(loadFields) < 3 {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, fmt.Errorf("unexpected format of loadavg"))
+	}
+
+	for i, name := range []string{"load1", "load5", "load15"} {
+		outputs[name], _ = strconv.ParseFloat(loadFields[i], 64)
+	}
+
+	memInfo, err := readMemInfo(procPath)
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+	}
+
+	memTotal := memInfo["MemTotal"]
+	memAvailable := memInfo["MemAvailable"]
+	swapTotal := memInfo["SwapTotal"]
+	swapUsed := swapTotal - memInfo["SwapFree"]
+
+	outputs["memTotalMB"] = memTotal / 1024
+	outputs["memAvailableMB"] = memAvailable / 1024
+	outputs["memUsedPercent"] = percentOf(memTotal-memAvailable, memTotal)
+	outputs["swapTotalMB"] = swapTotal / 1024
+	outputs["swapUsedMB"] = swapUsed / 1024
+	outputs["swapUsedPercent"] = percentOf(swapUsed, swapTotal)
+
+	// Format is: allocated, allocated but unused (always 0 on modern kernels), max
+	fileNr, err := os.ReadFile(filepath.Join(procPath, "sys", "fs", "file-nr"))
+	if err != nil {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+	}
+
+	fileFields := strings.Fields(string(fileNr))
+	if len(fileFields) < 3 {
+		return result.NewFailedResult(m.Name, m.Target, m.ID, fmt.Errorf("unexpected format of file-nr"))
+	}
+
+	openFiles, _ := strconv.Atoi(fileFields[0])
+	unusedFiles, _ := strconv.Atoi(fileFields[1])
+	maxFiles, _ := strconv.Atoi(fileFields[2])
+
+	outputs["openFiles"] = openFiles - unusedFiles
+	outputs["maxFiles"] = maxFiles
+	outputs["openFilesPercent"] = percentOf(openFiles-unusedFiles, maxFiles)
+
+	diskUsedPercentMax := 0.0
+
+	for i, mount := range mounts {
+		var stat syscall.Statfs_t
+
+		err = syscall.Statfs(mount, &stat)
+		if err != nil {
+			return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(fmt.Errorf("disk %s: %w", mount, err)))
+		}
+
+		// Used is worked out from free blocks, but available is what non-root users can use
+		blockSize := int(stat.Bsize)
+		total := int(stat.Blocks) * blockSize     //nolint:gosec
+		used := total - int(stat.Bfree)*blockSize //nolint:gosec
+		available := int(stat.Bavail) * blockSize //nolint:gosec
+		usedPercent := percentOf(used, used+available)
+
+		outputs[fmt.Sprintf("disk%dPath", i+1)] = mount
+		outputs[fmt.Sprintf("disk%dTotalGB", i+1)] = float64(total) / (1 << 30)
+		outputs[fmt.Sprintf("disk%dFreeGB", i+1)] = float64(available) / (1 << 30)
+		outputs[fmt.Sprintf("disk%dUsedPercent", i+1)] = usedPercent
+
+		diskUsedPercentMax = max(diskUsedPercentMax, usedPercent)
+	}
+
+	outputs["diskUsedPercentMax"] = diskUsedPercentMax
+
+	r.Value = cpuPercent
+	r.Outputs = outputs
+
+	return r
+}
+
+// Read the aggregate CPU counters from /proc/stat, returns total and idle time
+func readCPUTimes(procPath string) (uint64, uint64, error) {
+	stat, err := os.ReadFile(filepath.Join(procPath, "stat"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	line, _, _ := strings.Cut(string(stat), "\n")
+
+	// Fields are user, nice, system, idle, iowait, irq, softirq, steal, guest, guest_nice
+	fields := strings.Fields(line)
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, fmt.Errorf("unexpected format of /proc/stat")
+	}
+
+	var total, idle uint64
+
+	// Guest time is already counted in user time, so is skipped
+	for i, field := range fields[1:min(len(fields), 9)] {
+		val, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		total += val
+
+		// Both idle and iowait count as idle time
+		if i == 3 || i == 4 {
+			idle += val
+		}
+	}
+
+	return total, idle, nil
+}
+
+// Read /proc/meminfo into a map of values in kB
+func readMemInfo(procPath string) (map[string]int, error) {
+	file, err := os.Open(filepath.Join(procPath, "meminfo"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	memInfo := map[string]int{}
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+
+		fields := strings.Fields(value)
+		if len(fields) > 0 {
+			memInfo[key], _ = strconv.Atoi(fields[0])
+		}
+	}
+
+	if _, ok := memInfo["MemTotal"]; !ok {
+		return nil, fmt.Errorf("unexpected format of meminfo")
+	}
+
+	return memInfo, scanner.Err()
+}
+
+func percentOf(value, total int) float64 {
+	if total <= 0 {
+		return 0
+	}
+
+	return float64(value) / float64(total) * 100
+}
@@ -0,0 +1,17 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon Runner - System monitor stub, for platforms without /proc
+// ----------------------------------------------------------------------------
+
+//go:build !linux
+
+package monitor
+
+import (
+	"fmt"
+	"nanomon/services/common/result"
+)
+
+func (m *Monitor) runSystem() *result.Result {
+	return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(fmt.Errorf("system monitor is only supported on Linux")))
+}
@@ -23,7 +23,7 @@ func (m *Monitor) runTCP() *result.Result {
 	if timeoutProp != "" {
 		timeout, err = time.ParseDuration(timeoutProp)
 		if err != nil {
-			return result.NewFailedResult(m.Name, m.Target, m.ID, err)
+			return result.NewFailedResult(m.Name, m.Target, m.ID, result.ConfigError(err))
 		}
 	}
 
@@ -35,10 +35,10 @@ func (m *Monitor) runTCP() *result.Result {
 		return result.NewFailedResult(m.Name, m.Target, m.ID, err)
 	}
 
-	r.Value = int(time.Since(start).Milliseconds())
+	r.Value = durationMs(time.Since(start))
 
 	outputs := map[string]any{
-		"respTime":  r.Value,
+		"respTime":  int(r.Value),
 		"ipAddress": strings.Split(conn.RemoteAddr().String(), ":")[0],
 	}
 
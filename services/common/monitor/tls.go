@@ -0,0 +1,97 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon Runner - Shared TLS helpers, for CA bundles & client certificates
+// ----------------------------------------------------------------------------
+
+package monitor
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Load PEM data, which can be given inline, as an env var with 'env:NAME' so it
+// can be injected from a secret, or as the path to a file
+func loadPEM(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+
+	if strings.HasPrefix(value, "-----BEGIN") {
+		return []byte(value), nil
+	}
+
+	if envName, ok := strings.CutPrefix(value, "env:"); ok {
+		envValue := os.Getenv(envName)
+		if envValue == "" {
+			return nil, fmt.Errorf("env var %s is not set", envName)
+		}
+
+		return []byte(envValue), nil
+	}
+
+	return os.ReadFile(value)
+}
+
+// Load the CA bundle given in the caCert property
+func loadCACerts(caCertProp string) (*x509.CertPool, error) {
+	caPEM, err := loadPEM(caCertProp)
+	if err != nil {
+		return nil, fmt.Errorf("caCert: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("caCert: no valid certificates found")
+	}
+
+	return pool, nil
+}
+
+// Load the client certificate & key for mTLS given in the clientCert and clientKey properties
+func loadClientCert(clientCertProp, clientKeyProp string) (*tls.Certificate, error) {
+	if clientCertProp == "" || clientKeyProp == "" {
+		return nil, fmt.Errorf("clientCert and clientKey must both be set")
+	}
+
+	certPEM, err := loadPEM(clientCertProp)
+	if err != nil {
+		return nil, fmt.Errorf("clientCert: %w", err)
+	}
+
+	keyPEM, err := loadPEM(clientKeyProp)
+	if err != nil {
+		return nil, fmt.Errorf("clientKey: %w", err)
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("clientCert: %w", err)
+	}
+
+	return &cert, nil
+}
+
+// Apply the caCert, clientCert and clientKey properties to a TLS config
+func applyTLSProps(config *tls.Config, props map[string]string) error {
+	if props["caCert"] != "" {
+		pool, err := loadCACerts(props["caCert"])
+		if err != nil {
+			return err
+		}
+
+		config.RootCAs = pool
+	}
+
+	if props["clientCert"] != "" || props["clientKey"] != "" {
+		clientCert, err := loadClientCert(props["clientCert"], props["clientKey"])
+		if err != nil {
+			return err
+		}
+
+		config.Certificates = []tls.Certificate{*clientCert}
+	}
+
+	return nil
+}
@@ -14,12 +14,12 @@ func (r *Result) Store(db *database.DB) error {
 	}
 
 	query := `
-		INSERT INTO results (date, monitor_id, monitor_name, monitor_target, status, value, message, outputs)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		INSERT INTO results (date, monitor_id, monitor_name, monitor_target, status, value, message, outputs, error_class)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 	`
 
 	_, err = db.Handle.Exec(query, r.Date, r.MonitorID, r.MonitorName, r.MonitorTarget,
-		r.Status, r.Value, r.Message, outputsJSON)
+		r.Status, r.Value, r.Message, outputsJSON, r.ErrorClass)
 	if err != nil {
 		return err
 	}
@@ -28,16 +28,17 @@ func (r *Result) Store(db *database.DB) error {
 }
 
 // Get results for a specific monitor by ID, limited to max results
-func GetResultsForMonitor(db *database.DB, monitorID int, max int) ([]*Result, error) {
+// When errorClass isn't empty only results with that class are returned
+func GetResultsForMonitor(db *database.DB, monitorID int, max int, errorClass string) ([]*Result, error) {
 	query := `
-		SELECT date, monitor_id, monitor_name, monitor_target, status, value, message, outputs
+		SELECT date, monitor_id, monitor_name, monitor_target, status, value, message, outputs, error_class
 		FROM results
-		WHERE monitor_id = $1
+		WHERE monitor_id = $1 AND ($3::VARCHAR = '' OR error_class = $3::VARCHAR)
 		ORDER BY date DESC
 		LIMIT $2
 	`
 
-	rows, err := db.Handle.Query(query, monitorID, max)
+	rows, err := db.Handle.Query(query, monitorID, max, errorClass)
 	if err != nil {
 		return nil, err
 	}
@@ -51,7 +52,7 @@ func GetResultsForMonitor(db *database.DB, monitorID int, max int) ([]*Result, e
 		var outputsJSON string
 
 		if err := rows.Scan(&r.Date, &r.MonitorID, &r.MonitorName, &r.MonitorTarget,
-			&r.Status, &r.Value, &r.Message, &outputsJSON); err != nil {
+			&r.Status, &r.Value, &r.Message, &outputsJSON, &r.ErrorClass); err != nil {
 			return nil, err
 		}
 
@@ -66,15 +67,17 @@ func GetResultsForMonitor(db *database.DB, monitorID int, max int) ([]*Result, e
 }
 
 // Get all results, limited to max results
-func GetResults(db *database.DB, max int) ([]*Result, error) {
+// When errorClass isn't empty only results with that class are returned
+func GetResults(db *database.DB, max int, errorClass string) ([]*Result, error) {
 	query := `
-		SELECT date, monitor_id, monitor_name, monitor_target, status, value, message, outputs
+		SELECT date, monitor_id, monitor_name, monitor_target, status, value, message, outputs, error_class
 		FROM results
+		WHERE $2::VARCHAR = '' OR error_class = $2::VARCHAR
 		ORDER BY date DESC
 		LIMIT $1
 	`
 
-	rows, err := db.Handle.Query(query, max)
+	rows, err := db.Handle.Query(query, max, errorClass)
 	if err != nil {
 		return nil, err
 	}
@@ -88,7 +91,7 @@ func GetResults(db *database.DB, max int) ([]*Result, error) {
 		var outputsJSON string
 
 		if err := rows.Scan(&r.Date, &r.MonitorID, &r.MonitorName, &r.MonitorTarget,
-			&r.Status, &r.Value, &r.Message, &outputsJSON); err != nil {
+			&r.Status, &r.Value, &r.Message, &outputsJSON, &r.ErrorClass); err != nil {
 			return nil, err
 		}
 
@@ -0,0 +1,95 @@
+// ----------------------------------------------------------------------------
+// Copyright (c) Ben Coleman, 2023. Licensed under the MIT License.
+// NanoMon - Classification of failures, so they can be filtered & alerted on
+// ----------------------------------------------------------------------------
+
+package result
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// Classes of error, a result which is OK or couldn't be classified has no class
+const ErrorDNS = "dns"
+const ErrorTimeout = "timeout"
+const ErrorConnRefused = "connection_refused"
+const ErrorTLS = "tls"
+const ErrorHTTPStatus = "http_status"
+const ErrorRule = "rule"
+const ErrorConfig = "config"
+
+var ErrorClasses = []string{ErrorDNS, ErrorTimeout, ErrorConnRefused, ErrorTLS, ErrorHTTPStatus, ErrorRule, ErrorConfig}
+
+// An error with a class set by the checker, for failures which can't be told apart by type
+type ClassError struct {
+	Class string
+	Err   error
+}
+
+func (e *ClassError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ClassError) Unwrap() error {
+	return e.Err
+}
+
+// Give an error a class, the message of the error is unchanged
+func WithClass(class string, err error) error {
+	return &ClassError{Class: class, Err: err}
+}
+
+// Shorthand for errors with the properties or settings of a monitor
+func ConfigError(err error) error {
+	return WithClass(ErrorConfig, err)
+}
+
+// Find the class of an error from the errors it wraps, DNS is checked first as
+// lookups can also time out. Returns empty when the error can't be classified
+func ClassifyError(err error) string {
+	var classErr *ClassError
+	if errors.As(err, &classErr) {
+		return classErr.Class
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return ErrorDNS
+	}
+
+	if isTLSError(err) {
+		return ErrorTLS
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return ErrorConnRefused
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) ||
+		(errors.As(err, &netErr) && netErr.Timeout()) {
+		return ErrorTimeout
+	}
+
+	return ""
+}
+
+func isTLSError(err error) bool {
+	var (
+		verifyErr    *tls.CertificateVerificationError
+		recordErr    tls.RecordHeaderError
+		alertErr     tls.AlertError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+
+	return errors.As(err, &verifyErr) || errors.As(err, &recordErr) || errors.As(err, &alertErr) ||
+		errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr)
+}
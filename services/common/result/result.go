@@ -13,17 +13,26 @@ const StatusOK = 0
 const StatusError = 1
 const StatusFailed = 2
 
+// Warning was added after the others, so the values don't sort by severity, see Severity()
+const StatusWarning = 3
+
 type Result struct {
 	Date    time.Time `json:"date"`
 	Status  int       `json:"status"`
-	Value   int       `json:"value"`
+	Value   float64   `json:"value"`
 	Message string    `json:"message"`
 
+	// Why the result failed or isn't OK, one of the ErrorClasses, empty when OK
+	ErrorClass string `json:"error_class,omitempty"`
+
 	MonitorID     int    `json:"monitor_id"`
 	MonitorName   string `json:"monitor_name"`
 	MonitorTarget string `json:"monitor_target"`
 
 	Outputs map[string]any `json:"outputs"`
+
+	// Outputs only available to rules, these are never stored or sent to Prometheus
+	RuleOutputs map[string]any `json:"-"`
 }
 
 func NewResult(monName string, monTarget string, monID int) *Result {
@@ -44,8 +53,41 @@ func NewFailedResult(monName string, monTarget string, monID int, err error) *Re
 		Status:        StatusFailed,
 		Value:         0,
 		Message:       err.Error(),
+		ErrorClass:    ClassifyError(err),
 		MonitorName:   monName,
 		MonitorTarget: monTarget,
 		MonitorID:     monID,
 	}
 }
+
+// All outputs including the rule only ones, used as the parameters for rules
+func (r *Result) RuleParams() map[string]any {
+	if len(r.RuleOutputs) == 0 {
+		return r.Outputs
+	}
+
+	params := make(map[string]any, len(r.Outputs)+len(r.RuleOutputs))
+	for k, v := range r.Outputs {
+		params[k] = v
+	}
+
+	for k, v := range r.RuleOutputs {
+		params[k] = v
+	}
+
+	return params
+}
+
+// Severity of a status for comparing them, from 0 for OK up to 3 for failed
+func Severity(status int) int {
+	switch status {
+	case StatusOK:
+		return 0
+	case StatusWarning:
+		return 1
+	case StatusError:
+		return 2
+	default:
+		return 3
+	}
+}
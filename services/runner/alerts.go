@@ -15,6 +15,7 @@ import (
 	"net/smtp"
 	"os"
 	"strconv"
+	"strings"
 	"text/template"
 )
 
@@ -72,6 +73,17 @@ func checkForAlerts(m *monitor.Monitor, r *result.Result) {
 		maxFailCount, _ = strconv.Atoi(maxFailCountEnv)
 	}
 
+	// Warnings only alert when enabled, they use the same count threshold as failures
+	if m.WarningCount > 0 && os.Getenv("ALERT_ON_WARNING") == "true" {
+		log.Printf("  Monitor '%s' has warned %d times...", m.Name, m.WarningCount)
+
+		if m.WarningCount >= maxFailCount && !m.InWarningState {
+			sendAlert(m, r, true, fmt.Sprintf("NanoMon warning for: %s", m.Name))
+
+			m.InWarningState = true
+		}
+	}
+
 	// Monitor hasn't failed, nothing to do!
 	if m.ErrorCount <= 0 {
 		return
@@ -79,38 +91,61 @@ func checkForAlerts(m *monitor.Monitor, r *result.Result) {
 
 	log.Printf("  Monitor '%s' has failed %d times...", m.Name, m.ErrorCount)
 
+	if m.ErrorCount >= maxFailCount && !m.InErrorState {
+		sendAlert(m, r, false, fmt.Sprintf("NanoMon alert for: %s", m.Name))
+
+		m.InErrorState = true
+	}
+}
+
+// sendAlert renders the email template for the monitor result and sends it
+func sendAlert(m *monitor.Monitor, r *result.Result, warning bool, subject string) {
+	if emailTemplate == nil {
+		return
+	}
+
 	alertData := struct {
-		Monitor *monitor.Monitor
-		Result  result.Result
-		LinkURL string
+		Monitor    *monitor.Monitor
+		Target     string
+		Properties map[string]string
+		Result     result.Result
+		LinkURL    string
+		Warning    bool
 	}{
-		Monitor: m,
-		Result:  *r,
-		LinkURL: linkURL,
+		Monitor:    m,
+		Target:     monitor.RedactTarget(m.Target),
+		Properties: monitor.RedactProps(m.Properties),
+		Result:     *r,
+		LinkURL:    linkURL,
+		Warning:    warning,
 	}
 
-	if m.ErrorCount >= maxFailCount && !m.InErrorState {
-		if emailTemplate == nil {
-			return
-		}
-
-		w := &bytes.Buffer{}
-		err := emailTemplate.Execute(w, alertData)
-		body := w.String()
+	w := &bytes.Buffer{}
+	err := emailTemplate.Execute(w, alertData)
+	body := w.String()
 
-		if err != nil {
-			log.Printf("  Error executing email template: %s", err)
-			return
-		}
+	if err != nil {
+		log.Printf("  Error executing email template: %s", err)
+		return
+	}
 
-		sendEmail(body, fmt.Sprintf("NanoMon alert for: %s", m.Name))
+	sendEmail(body, subject, alertRecipient(r.ErrorClass))
+}
 
-		m.InErrorState = true
+// alertRecipient routes alerts by the error class of the result, e.g. ALERT_SMTP_TO_TLS
+// sends TLS failures to another address, anything without a route goes to ALERT_SMTP_TO
+func alertRecipient(errorClass string) string {
+	if errorClass != "" {
+		if classTo := os.Getenv("ALERT_SMTP_TO_" + strings.ToUpper(errorClass)); classTo != "" {
+			return classTo
+		}
 	}
+
+	return to
 }
 
 // sendEmail sends an email alert using the configured SMTP settings
-func sendEmail(body, subject string) {
+func sendEmail(body, subject, recipient string) {
 	// Alerting is not configured and disabled
 	if !IsAlertingEnabled() {
 		log.Printf("  Alerting is disabled")
@@ -125,7 +160,7 @@ func sendEmail(body, subject string) {
 
 	auth := smtp.PlainAuth("", from, pass, host)
 
-	err := smtp.SendMail(host+":"+port, auth, from, []string{to}, []byte(msg))
+	err := smtp.SendMail(host+":"+port, auth, from, []string{recipient}, []byte(msg))
 	if err != nil {
 		log.Printf("Alert SMTP error: %s", err)
 		return
@@ -155,7 +155,7 @@ func handleNotification(notification *pq.Notification) {
 
 				monitors[i] = updatedMon
 
-				log.Printf("Monitor updated: %s", notification.Extra)
+				log.Printf("Monitor updated: %s", updatedMon.Name)
 
 				return
 			}
@@ -16,6 +16,11 @@ CREATE TABLE monitors (
   interval VARCHAR(50) NOT NULL,
   target VARCHAR(512) NOT NULL,
   rule VARCHAR(255) NOT NULL,
+  warn_rule VARCHAR(255) DEFAULT '',
+  crit_rule VARCHAR(255) DEFAULT '',
+  rule_engine VARCHAR(20) DEFAULT '',
+  value_unit VARCHAR(20) DEFAULT '',
+  value_precision INT DEFAULT 0,
   enabled BOOLEAN DEFAULT TRUE,
   updated TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
   group_name VARCHAR(100) DEFAULT 'default',
@@ -32,12 +37,24 @@ CREATE TABLE results (
   status INT NOT NULL,
   value DOUBLE PRECISION NOT NULL,
   message VARCHAR(512) DEFAULT '',
-  outputs JSONB DEFAULT '{}'::JSONB
+  outputs JSONB DEFAULT '{}'::JSONB,
+  error_class VARCHAR(30) DEFAULT ''
+);
+
+-- Create snapshots table, holds response bodies for monitors with change detection
+CREATE TABLE snapshots (
+  id SERIAL PRIMARY KEY,
+  date TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  monitor_id INT REFERENCES monitors(id) ON DELETE CASCADE,
+  hash VARCHAR(64) NOT NULL,
+  body TEXT DEFAULT ''
 );
 
 -- Add indexes
 CREATE INDEX idx_monitor_id ON results(monitor_id);
 CREATE INDEX idx_date ON results(date);
+CREATE INDEX idx_error_class ON results(error_class);
+CREATE INDEX idx_snapshots_monitor_id ON snapshots(monitor_id);
 
 -- Function to notify on new monitor insertion
 CREATE OR REPLACE FUNCTION notify_monitor_insert()
@@ -52,6 +69,11 @@ BEGIN
           'interval', NEW.interval,
           'target', NEW.target,
           'rule', NEW.rule,
+          'warnRule', NEW.warn_rule,
+          'critRule', NEW.crit_rule,
+          'ruleEngine', NEW.rule_engine,
+          'unit', NEW.value_unit,
+          'precision', NEW.value_precision,
           'enabled', NEW.enabled,
           'updated', NEW.updated,
           'group', NEW.group_name,
@@ -75,6 +97,11 @@ BEGIN
             'interval', NEW.interval,
             'target', NEW.target,
             'rule', NEW.rule,
+            'warnRule', NEW.warn_rule,
+            'critRule', NEW.crit_rule,
+            'ruleEngine', NEW.rule_engine,
+            'unit', NEW.value_unit,
+            'precision', NEW.value_precision,
             'enabled', NEW.enabled,
             'updated', NEW.updated,
             'group', NEW.group_name,
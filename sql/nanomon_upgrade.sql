@@ -0,0 +1,91 @@
+-- Upgrade a database created by an older version of nanomon_init.sql
+-- Safe to run more than once, anything which already exists is left alone
+-- psql -h {host} -U nanomon -d nanomon -f sql/nanomon_upgrade.sql
+
+--- Check if the current database is 'nanomon' prevents accidental execution on the wrong database
+SELECT current_database() AS db_name;
+DO $$
+BEGIN
+    IF current_database() != 'nanomon' THEN
+        RAISE EXCEPTION 'This script should only be run on the nanomon database!';
+    END IF;
+END $$;
+
+
+-- Snapshots table, holds response bodies for monitors with change detection
+CREATE TABLE IF NOT EXISTS snapshots (
+  id SERIAL PRIMARY KEY,
+  date TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  monitor_id INT REFERENCES monitors(id) ON DELETE CASCADE,
+  hash VARCHAR(64) NOT NULL,
+  body TEXT DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_snapshots_monitor_id ON snapshots(monitor_id);
+
+-- Monitor columns, added after the first release
+ALTER TABLE monitors ADD COLUMN IF NOT EXISTS warn_rule VARCHAR(255) DEFAULT '';
+ALTER TABLE monitors ADD COLUMN IF NOT EXISTS crit_rule VARCHAR(255) DEFAULT '';
+ALTER TABLE monitors ADD COLUMN IF NOT EXISTS rule_engine VARCHAR(20) DEFAULT '';
+ALTER TABLE monitors ADD COLUMN IF NOT EXISTS value_unit VARCHAR(20) DEFAULT '';
+ALTER TABLE monitors ADD COLUMN IF NOT EXISTS value_precision INT DEFAULT 0;
+
+-- Result columns, added after the first release
+ALTER TABLE results ADD COLUMN IF NOT EXISTS error_class VARCHAR(30) DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_error_class ON results(error_class);
+
+-- Notify functions, replaced so the notifications include the new columns
+CREATE OR REPLACE FUNCTION notify_monitor_insert()
+RETURNS TRIGGER AS $$
+BEGIN
+    -- Send notification with the new monitor data as JSON
+    PERFORM pg_notify('new_monitor', 
+        json_build_object(
+          'id', NEW.id,
+          'name', NEW.name,
+          'type', NEW.type,
+          'interval', NEW.interval,
+          'target', NEW.target,
+          'rule', NEW.rule,
+          'warnRule', NEW.warn_rule,
+          'critRule', NEW.crit_rule,
+          'ruleEngine', NEW.rule_engine,
+          'unit', NEW.value_unit,
+          'precision', NEW.value_precision,
+          'enabled', NEW.enabled,
+          'updated', NEW.updated,
+          'group', NEW.group_name,
+          'properties', NEW.properties
+        )::text
+    );
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION notify_monitor_update()
+RETURNS TRIGGER AS $$
+BEGIN
+    -- Send notification with the updated monitor data as JSON
+    PERFORM pg_notify('monitor_updated', 
+        json_build_object(
+            'id', NEW.id,
+            'name', NEW.name,
+            'type', NEW.type,
+            'interval', NEW.interval,
+            'target', NEW.target,
+            'rule', NEW.rule,
+            'warnRule', NEW.warn_rule,
+            'critRule', NEW.crit_rule,
+            'ruleEngine', NEW.rule_engine,
+            'unit', NEW.value_unit,
+            'precision', NEW.value_precision,
+            'enabled', NEW.enabled,
+            'updated', NEW.updated,
+            'group', NEW.group_name,
+            'properties', NEW.properties
+        )::text
+    );
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;